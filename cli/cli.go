@@ -0,0 +1,144 @@
+// Package cli is the nova command, the generated CLI of projects with
+// plugins runs it too, see package plugin.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/sgq995/nova/internal/config"
+	"github.com/sgq995/nova/internal/fsys"
+	"github.com/sgq995/nova/internal/logger"
+	"github.com/sgq995/nova/internal/module"
+	"github.com/sgq995/nova/internal/must"
+	"github.com/sgq995/nova/internal/project"
+)
+
+func dev(c config.Config) {
+	nova := must.Must(project.Context(c))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGABRT, syscall.SIGTERM)
+	defer stop()
+
+	server, err := nova.Serve(ctx)
+	if err != nil {
+		logger.Errorf("%+v", err)
+		os.Exit(1)
+	}
+
+	<-ctx.Done()
+	// a second signal terminates right away
+	stop()
+
+	logger.Infof("shutting down...")
+	server.Dispose()
+}
+
+func build(c config.Config) {
+	nova := must.Must(project.Context(c))
+	err := nova.Build()
+	if err != nil {
+		logger.Errorf("%+v", err)
+		os.Exit(1)
+	}
+
+	// TODO: move go build execution to nova.Build
+	in := module.Join(c.Codegen.OutDir, "main.go")
+	out := module.Join(c.Codegen.OutDir, "app")
+	cmd := exec.Command("go", "build", "-o", out, in)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	logger.Infof("go build -o %s %s", out, in)
+	err = cmd.Run()
+	if err != nil {
+		logger.Errorf("%+v", err)
+		os.Exit(1)
+	}
+
+	logger.Infof("success (%s)", out)
+}
+
+func initCmd() {
+	filename := module.Abs("nova.config.json")
+
+	if must.Must(fsys.FileExists(filename)) {
+		logger.Errorf("nova.config.json already exists")
+		return
+	}
+
+	file := must.Must(os.Create(filename))
+	defer file.Close()
+
+	cfg := config.Default()
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(cfg)
+
+	logger.Infof("nova.config.json created")
+}
+
+// plugins runs the command with the plugins of the project instead, when it
+// has any.
+func plugins(c config.Config) {
+	err := runPlugins(&c)
+	if err != nil {
+		logger.Errorf("%+v", err)
+		os.Exit(1)
+	}
+}
+
+func help() {
+	flag.Usage()
+	fmt.Fprintf(flag.CommandLine.Output(), "\n%s %s\n", os.Args[0], "dev|build")
+}
+
+// Main runs nova with the arguments of the process.
+func Main() {
+	configFile := flag.String(
+		"config-file",
+		module.Abs("nova.config.json"),
+		"A JSON config file",
+	)
+
+	flag.Parse()
+
+	cfg := config.Default()
+	if *configFile != "" {
+		other, err := config.Read(*configFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			panic(err)
+		}
+		cfg.Merge(&other)
+	}
+
+	args := flag.Args()
+	if len(args) < 1 {
+		help()
+		return
+	}
+
+	switch args[0] {
+	case "dev":
+		plugins(cfg)
+		dev(cfg)
+
+	case "build":
+		plugins(cfg)
+		build(cfg)
+
+	case "init":
+		initCmd()
+
+	default:
+		help()
+	}
+}
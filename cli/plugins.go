@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	"github.com/sgq995/nova/internal/config"
+	"github.com/sgq995/nova/internal/logger"
+	"github.com/sgq995/nova/internal/module"
+)
+
+// pluginsFile registers the plugins of a project, see package plugin.
+const pluginsFile = "nova.plugins.go"
+
+// pluginsBuilt is set on the CLI built for a project, with -ldflags -X.
+var pluginsBuilt string
+
+const pluginsMain = `// Code generated by nova. DO NOT EDIT.
+
+package main
+
+import "github.com/sgq995/nova/cli"
+
+func main() {
+	cli.Main()
+}
+`
+
+// runPlugins hands the command over to a CLI compiled with the plugins of
+// the project and exits with its status. It returns when the project has no
+// plugins or nova runs with them already.
+func runPlugins(c *config.Config) error {
+	filename := module.Abs(pluginsFile)
+	if _, err := os.Stat(filename); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if pluginsBuilt != "" {
+		return nil
+	}
+
+	// go.mod and go.sum pin the plugins and nova itself
+	sources := []string{filename, module.Abs("go.mod"), module.Abs("go.sum")}
+	hash, err := hashFiles(sources...)
+	if err != nil {
+		return err
+	}
+
+	dir := module.Join(c.Codegen.OutDir, "plugins")
+	bin := filepath.Join(dir, "nova")
+	if runtime.GOOS == "windows" {
+		bin += ".exe"
+	}
+
+	sum := filepath.Join(dir, "nova.sum")
+	if built, err := os.ReadFile(sum); err != nil || string(built) != hash {
+		err := buildPlugins(dir, bin, filename)
+		if err != nil {
+			return err
+		}
+		// go build may add missing checksums to go.sum
+		hash, err = hashFiles(sources...)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(sum, []byte(hash), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Debugf("[cli] running %s", module.Rel(bin))
+	cmd := exec.Command(bin, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	// Ctrl-C reaches the whole group, the child gets SIGINT by itself and a
+	// second one would cut its graceful shutdown short. SIGTERM is usually
+	// sent to nova alone, so it is passed on.
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
+
+// buildPlugins compiles the CLI of the project, the plugins file is copied
+// next to a generated main so both build as a single package.
+func buildPlugins(dir string, bin string, filename string) error {
+	logger.Infof("building the plugins of %s...", pluginsFile)
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "main.go"), []byte(pluginsMain), 0644)
+	if err != nil {
+		return err
+	}
+
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(dir, "plugins.go"), contents, 0644)
+	if err != nil {
+		return err
+	}
+
+	ldflags := "-X github.com/sgq995/nova/cli.pluginsBuilt=true"
+	cmd := exec.Command("go", "build", "-tags", "nova", "-ldflags", ldflags, "-o", bin, "main.go", "plugins.go")
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("nova: %s: %w (does go.mod require github.com/sgq995/nova?)", pluginsFile, err)
+	}
+	return nil
+}
+
+// hashFiles hashes the contents of files, missing ones included as empty.
+func hashFiles(files ...string) (string, error) {
+	h := sha256.New()
+	for _, filename := range files {
+		contents, err := os.ReadFile(filename)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", filepath.Base(filename), len(contents))
+		h.Write(contents)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
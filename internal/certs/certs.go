@@ -0,0 +1,341 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sgq995/nova/internal/fsys"
+)
+
+const (
+	caCertFile = "ca.pem"
+	caKeyFile  = "ca-key.pem"
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 397 * 24 * time.Hour // the longest lifetime browsers accept
+
+	// leaves are renewed a little before they expire
+	leafRenewal = 7 * 24 * time.Hour
+)
+
+// Authority is the local root CA of nova, shared by every project of the
+// user. Leaf certificates are issued per host and persisted next to it.
+type Authority struct {
+	dir string
+
+	cert *x509.Certificate
+	key  crypto.Signer
+
+	mu     sync.Mutex
+	leaves map[string]*tls.Certificate
+}
+
+// Dir is where the authority and its leaves are stored.
+func Dir() (string, error) {
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config, "nova", "certs"), nil
+}
+
+// LoadAuthority reads the authority from dir, creating it on first use. It
+// reports whether the authority was created, so the user can be told how to
+// trust it.
+func LoadAuthority(dir string) (*Authority, bool, error) {
+	certPath := filepath.Join(dir, caCertFile)
+	keyPath := filepath.Join(dir, caKeyFile)
+
+	exists, err := fsys.FileExists(certPath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if exists {
+		cert, key, err := loadPair(certPath, keyPath)
+		if err != nil {
+			return nil, false, err
+		}
+		if time.Now().Before(cert.NotAfter) {
+			return newAuthority(dir, cert, key), false, nil
+		}
+	}
+
+	cert, key, err := createAuthority(certPath, keyPath)
+	if err != nil {
+		return nil, false, err
+	}
+	return newAuthority(dir, cert, key), true, nil
+}
+
+func newAuthority(dir string, cert *x509.Certificate, key crypto.Signer) *Authority {
+	return &Authority{
+		dir:    dir,
+		cert:   cert,
+		key:    key,
+		leaves: map[string]*tls.Certificate{},
+	}
+}
+
+// CertPath is the PEM file users add to their trust store.
+func (ca *Authority) CertPath() string {
+	return filepath.Join(ca.dir, caCertFile)
+}
+
+// Certificate returns the leaf certificate of host, loading or issuing it
+// when needed.
+func (ca *Authority) Certificate(host string) (*tls.Certificate, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		host = "localhost"
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if leaf, exists := ca.leaves[host]; exists && valid(leaf.Leaf) {
+		return leaf, nil
+	}
+
+	sum := sha256.Sum256([]byte(host))
+	name := hex.EncodeToString(sum[:8])
+	certPath := filepath.Join(ca.dir, "hosts", name+".pem")
+	keyPath := filepath.Join(ca.dir, "hosts", name+"-key.pem")
+
+	cert, key, err := loadPair(certPath, keyPath)
+	if err != nil || !valid(cert) || cert.CheckSignatureFrom(ca.cert) != nil {
+		cert, key, err = ca.issue(host, certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	leaf := &tls.Certificate{
+		Certificate: [][]byte{cert.Raw, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}
+	ca.leaves[host] = leaf
+
+	return leaf, nil
+}
+
+// TLSConfig serves a certificate for the host the client asks for, as long
+// as allowed accepts it. The root is trusted system-wide, so clients must
+// not get it to sign names of their choice. Clients send no server name when
+// connecting to an IP, the certificate is then issued for the local address
+// of the connection, or fallback.
+func (ca *Authority) TLSConfig(fallback string, allowed func(host string) bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			host := hello.ServerName
+			if host == "" {
+				host = fallback
+				if addr, ok := hello.Conn.LocalAddr().(*net.TCPAddr); ok {
+					host = addr.IP.String()
+				}
+			}
+			if host != "" && !allowed(host) {
+				return nil, fmt.Errorf("nova: no certificate for %q, it is not a host of the dev server", host)
+			}
+			return ca.Certificate(host)
+		},
+	}
+}
+
+func valid(cert *x509.Certificate) bool {
+	return cert != nil && time.Now().Add(leafRenewal).Before(cert.NotAfter)
+}
+
+func (ca *Authority) issue(host string, certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"nova development certificate"},
+			CommonName:   host,
+		},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(leafValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = writePair(certPath, keyPath, der, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, key, nil
+}
+
+func createAuthority(certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user := "nova"
+	if hostname, err := os.Hostname(); err == nil {
+		user = hostname
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"nova development CA"},
+			CommonName:   "nova development CA (" + user + ")",
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = writePair(certPath, keyPath, der, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, key, nil
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func loadPair(certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("nova: invalid certificate %s", certPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("nova: invalid key %s", keyPath)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("nova: unsupported key type " + keyPath)
+	}
+
+	return cert, key, nil
+}
+
+func writePair(certPath, keyPath string, der []byte, key crypto.Signer) error {
+	err := os.MkdirAll(filepath.Dir(certPath), 0700)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// TrustInstructions explains how to add the CA at certPath to the trust store
+// of the current platform.
+func TrustInstructions(certPath string) string {
+	var steps string
+	switch runtime.GOOS {
+	case "darwin":
+		steps = fmt.Sprintf("  sudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain %q", certPath)
+
+	case "windows":
+		steps = fmt.Sprintf("  certutil -addstore -user Root %q", certPath)
+
+	default:
+		steps = fmt.Sprintf("  sudo cp %q /usr/local/share/ca-certificates/nova.crt && sudo update-ca-certificates\n", certPath) +
+			fmt.Sprintf("  Firefox and Chrome keep their own store: import %q under Settings > Certificates > Authorities", certPath)
+	}
+
+	return "nova created a local certificate authority for HTTPS in dev, trust it once with:\n" + steps
+}
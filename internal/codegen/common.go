@@ -5,15 +5,46 @@ func renderHandler(root string, templates []string, render func(*template.Templa
 	{{- if .IsProd}}
 	sub := must(fs.Sub(templatesFS, root))
 	t := template.Must(template.ParseFS(sub, templates...))
+	{{- else}}
+	dir := filepath.Join("{{.Root}}", root)
+	load := devTemplates(dir, templates)
 	{{- end}}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		{{if not .IsProd -}}
-		fs := os.DirFS(filepath.Join("{{.Root}}", root))
-		t := template.Must(template.ParseFS(fs, templates...)){{end}}
+		t := load(){{end}}
 		err := render(t, w, r)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	})
 }
-`
+{{if not .IsProd}}
+// devTemplates parses templates once and again only when one of them
+// changes on disk, a parse error panics so nova shows it.
+func devTemplates(dir string, templates []string) func() *template.Template {
+	var (
+		mu       sync.Mutex
+		t        *template.Template
+		modTimes []time.Time
+	)
+
+	return func() *template.Template {
+		current := make([]time.Time, len(templates))
+		for i, name := range templates {
+			if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				current[i] = info.ModTime()
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if t == nil || !slices.EqualFunc(current, modTimes, time.Time.Equal) {
+			t = nil // retried on the next request if parsing panics
+			t = template.Must(template.ParseFS(os.DirFS(dir), templates...))
+			modTimes = current
+		}
+		return t
+	}
+}
+{{end}}`
@@ -0,0 +1,104 @@
+package codegen
+
+import (
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/sgq995/nova/internal/module"
+	"github.com/sgq995/nova/internal/router"
+)
+
+const mainDevServer string = `package main
+
+import (
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+	{{range $alias, $package := .Imports}}
+	{{$alias}} "{{$package}}"{{end}}
+)
+
+{{template "renderHandler" .}}
+
+func main() {
+	// nova keeps stdin open while it owns this process
+	go func() {
+		io.Copy(io.Discard, os.Stdin)
+		os.Exit(0)
+	}()
+
+	mux := http.NewServeMux()
+	{{- range $filename, $handler := .Handlers}}
+	// {{$filename}}
+	{{with $render := $handler.Render}}mux.Handle("{{$render.Pattern}}", renderHandler("{{$render.Root}}", []string{ {{- range $render.Templates}}"{{.}}", {{end -}} }, {{$handler.Package}}.{{$render.Handler}})){{end}}
+	{{range $handler.Rest}}mux.HandleFunc("{{.Pattern}}", {{$handler.Package}}.{{.Handler}})
+	{{end}}
+	{{end}}
+	{{range .Setup}}
+	{{.}}
+	{{end}}
+
+	// nova
+	mux.HandleFunc("GET /@nova/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	s := http.Server{
+		Addr:    os.Getenv("NOVA_DEV_ADDR"),
+		Handler: mux,
+	}
+	err := s.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Fatalln(err)
+	}
+}
+`
+
+var mainDevServerTmpl *template.Template = newDevServerTemplate()
+
+func newDevServerTemplate() *template.Template {
+	mainTemplate := template.Must(template.New("main.go").Parse(mainDevServer))
+	template.Must(mainTemplate.New("renderHandler").Parse(renderHandlerFunc))
+	return mainTemplate
+}
+
+func (c *Codegen) GenerateDevServer(files map[string][]router.Route) error {
+	outDir := module.Join(c.config.Codegen.OutDir, "dev")
+	err := os.MkdirAll(outDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	imports, handlers := collectRouteHandlers(files)
+	setup, err := pluginSnippets(imports, true)
+	if err != nil {
+		return err
+	}
+
+	out := filepath.Join(outDir, "main.go")
+	file, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = mainDevServerTmpl.Execute(file, map[string]any{
+		"IsProd":   false,
+		"Root":     module.Abs(c.config.Router.Src),
+		"Imports":  imports,
+		"Handlers": handlers,
+		"Setup":    setup,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sgq995/nova/plugin"
+)
+
+// pluginSnippets adds the imports of the Codegen hooks to imports and
+// returns their setup code in registration order. An alias already taken by
+// another package is an error.
+func pluginSnippets(imports map[string]string, dev bool) ([]string, error) {
+	setup := []string{}
+	for _, p := range plugin.Hooks[plugin.Codegen]() {
+		snippet := p.Codegen(dev)
+		for alias, pkg := range snippet.Imports {
+			if other, exists := imports[alias]; exists && other != pkg {
+				return nil, fmt.Errorf("nova: plugin %s: import %s %q conflicts with %q", p.Name(), alias, pkg, other)
+			}
+			imports[alias] = pkg
+		}
+		if snippet.Setup != "" {
+			code := strings.ReplaceAll(snippet.Setup, "\n", "\n\t")
+			setup = append(setup, fmt.Sprintf("// %s\n\t%s", p.Name(), code))
+		}
+	}
+	return setup, nil
+}
@@ -19,6 +19,7 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
+	"os"
 	{{range $alias, $package := .Imports}}
 	{{$alias}} "{{$package}}"{{end}}
 )
@@ -43,12 +44,24 @@ func must[T any](obj T, err error) T {
 {{template "renderHandler" .}}
 
 func main() {
+	// defaults set by nova build, the environment of the process wins
+	for key, value := range map[string]string{ {{- range $key, $value := .Env}}
+		{{printf "%q" $key}}: {{printf "%q" $value}},{{end}}
+	} {
+		if _, exists := os.LookupEnv(key); !exists {
+			os.Setenv(key, value)
+		}
+	}
+
 	mux := http.NewServeMux()
-	{{- $global := . -}}
-	{{range $filename, $handler := .Handlers}}
+	{{- range $filename, $handler := .Handlers}}
 	// {{$filename}}
 	{{with $render := $handler.Render}}mux.Handle("{{$render.Pattern}}", renderHandler("{{$render.Root}}", []string{ {{- range $render.Templates}}"{{.}}", {{end -}} }, {{$handler.Package}}.{{$render.Handler}})){{end}}
-	{{range $handler.Rest}}mux.HandleFunc("{{.Pattern}}", {{$handler.Package}}.{{.Handler}}){{end}}
+	{{range $handler.Rest}}mux.HandleFunc("{{.Pattern}}", {{$handler.Package}}.{{.Handler}})
+	{{end}}
+	{{end}}
+	{{range .Setup}}
+	{{.}}
 	{{end}}
 
 	// nova
@@ -80,20 +93,7 @@ type routeHandler struct {
 	Package string
 }
 
-func (c *Codegen) GenerateProductionServer(files map[string][]router.Route) error {
-	outDir := module.Abs(c.config.Codegen.OutDir)
-	err := os.MkdirAll(outDir, 0755)
-	if err != nil {
-		return err
-	}
-
-	out := filepath.Join(outDir, "main.go")
-	file, err := os.Create(out)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
+func collectRouteHandlers(files map[string][]router.Route) (map[string]string, map[string]routeHandler) {
 	imports := map[string]string{}
 	handlers := map[string]routeHandler{}
 	for filename, routes := range files {
@@ -127,10 +127,38 @@ func (c *Codegen) GenerateProductionServer(files map[string][]router.Route) erro
 		handlers[filename] = handler
 	}
 
+	return imports, handlers
+}
+
+// GenerateProductionServer writes the main.go of the production server, env
+// holds the defaults of its environment variables. They are written in plain
+// text, so env must never hold secrets.
+func (c *Codegen) GenerateProductionServer(files map[string][]router.Route, env map[string]string) error {
+	outDir := module.Abs(c.config.Codegen.OutDir)
+	err := os.MkdirAll(outDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	imports, handlers := collectRouteHandlers(files)
+	setup, err := pluginSnippets(imports, false)
+	if err != nil {
+		return err
+	}
+
+	out := filepath.Join(outDir, "main.go")
+	file, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
 	err = mainProdServerTempl.Execute(file, map[string]any{
 		"IsProd":   true,
 		"Imports":  imports,
 		"Handlers": handlers,
+		"Setup":    setup,
+		"Env":      env,
 		"Host":     c.config.Server.Host,
 		"Port":     c.config.Server.Port,
 	})
@@ -14,16 +14,122 @@ import (
 const mainRouteModule string = `package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
-	
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
 	{{.Alias}} "{{.Package}}"
 )
 
+// frame types, they must match internal/server/frame.go
+const (
+	frameHead byte = iota + 1
+	frameData
+	frameTrailer
+	frameFlush
+	frameEnd
+	frameCancel
+	frameHijack
+	frameConnData
+	frameConnClose
+	frameLog
+	frameError
+)
+
+const (
+	frameHeaderSize = 5
+	frameMaxPayload = 1 << 24
+	frameChunkSize  = 32 << 10
+)
+
+// cancelGracePeriod is how long the handler may run once the dev server
+// went away.
+const cancelGracePeriod = 5 * time.Second
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var head [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(head[1:])
+	if length > frameMaxPayload {
+		return 0, nil, errors.New("nova: frame too large")
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return head[0], payload, nil
+}
+
+type frameWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (fw *frameWriter) write(typ byte, payload []byte) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	var head [frameHeaderSize]byte
+	head[0] = typ
+	binary.BigEndian.PutUint32(head[1:], uint32(len(payload)))
+
+	if _, err := fw.w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := fw.w.Write(payload)
+	return err
+}
+
+func (fw *frameWriter) writeJSON(typ byte, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return fw.write(typ, b)
+}
+
+func (fw *frameWriter) writeChunks(typ byte, b []byte) error {
+	for len(b) > 0 {
+		n := min(len(b), frameChunkSize)
+		if err := fw.write(typ, b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+type tlsState struct {
+	Version            uint16 ` + "`json:\"version\"`" + `
+	CipherSuite        uint16 ` + "`json:\"cipherSuite\"`" + `
+	ServerName         string ` + "`json:\"serverName\"`" + `
+	NegotiatedProtocol string ` + "`json:\"negotiatedProtocol\"`" + `
+	HandshakeComplete  bool   ` + "`json:\"handshakeComplete\"`" + `
+}
+
 type request struct {
 	Method string ` + "`json:\"method\"`" + `
 
@@ -35,29 +141,32 @@ type request struct {
 
 	Header http.Header ` + "`json:\"headers\"`" + `
 
-	// Can be Stdin
-	// Body []byte ` + "`json:\"body\"`" + `
-
 	ContentLength int64 ` + "`json:\"contentLength\"`" + `
 
+	TransferEncoding []string ` + "`json:\"transferEncoding\"`" + `
+
 	Host string ` + "`json:\"host\"`" + `
 
-	// TODO:
-	// Trailer http.Header
+	Trailer []string ` + "`json:\"trailer\"`" + `
 
 	RemoteAddr string ` + "`json:\"remoteAddr\"`" + `
 
 	RequestURI string ` + "`json:\"requestUri\"`" + `
 
+	TLS *tlsState ` + "`json:\"tls\"`" + `
+
 	Pattern string ` + "`json:\"pattern\"`" + `
+
+	PathValues map[string]string ` + "`json:\"pathValues\"`" + `
 }
 
-func transformRequest(r *request) (*http.Request, error) {
+func transformRequest(ctx context.Context, r *request, body io.ReadCloser) (*http.Request, error) {
 	u, err := url.Parse(r.RawURL)
 	if err != nil {
 		return nil, err
 	}
-	return &http.Request{
+
+	req := &http.Request{
 		Method: r.Method,
 
 		URL: u,
@@ -68,10 +177,12 @@ func transformRequest(r *request) (*http.Request, error) {
 
 		Header: r.Header,
 
-		Body: os.Stdin,
+		Body: body,
 
 		ContentLength: r.ContentLength,
 
+		TransferEncoding: r.TransferEncoding,
+
 		Host: r.Host,
 
 		RemoteAddr: r.RemoteAddr,
@@ -79,58 +190,460 @@ func transformRequest(r *request) (*http.Request, error) {
 		RequestURI: r.RequestURI,
 
 		Pattern: r.Pattern,
-	}, nil
+	}
+
+	if len(r.Trailer) > 0 {
+		req.Trailer = make(http.Header)
+		for _, key := range r.Trailer {
+			req.Trailer[key] = nil
+		}
+	}
+
+	if r.TLS != nil {
+		req.TLS = &tls.ConnectionState{
+			Version:            r.TLS.Version,
+			CipherSuite:        r.TLS.CipherSuite,
+			ServerName:         r.TLS.ServerName,
+			NegotiatedProtocol: r.TLS.NegotiatedProtocol,
+			HandshakeComplete:  r.TLS.HandshakeComplete,
+		}
+	}
+
+	return req.WithContext(ctx), nil
 }
 
-type responseWriter struct {
-	wroteHeader bool
+type addr string
+
+func (a addr) Network() string { return "tcp" }
+
+func (a addr) String() string { return string(a) }
+
+// frameQueue buffers the bytes of data frames until they are read. Frames
+// are queued without waiting for the reader, so the frame loop always gets to
+// the control frames, like a cancel, behind them.
+type frameQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	err    error // returned once buf is drained, no more bytes are coming
+	closed bool  // the reader is done, bytes are dropped
+}
+
+func newFrameQueue() *frameQueue {
+	q := &frameQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *frameQueue) Read(p []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.buf.Len() == 0 && q.err == nil && !q.closed {
+		q.cond.Wait()
+	}
+	switch {
+	case q.closed:
+		return 0, io.ErrClosedPipe
+
+	case q.buf.Len() > 0:
+		return q.buf.Read(p)
+
+	default:
+		return 0, q.err
+	}
+}
+
+func (q *frameQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.buf.Reset()
+	q.cond.Broadcast()
+	return nil
+}
+
+func (q *frameQueue) write(p []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed && q.err == nil {
+		q.buf.Write(p)
+	}
+	q.cond.Broadcast()
+}
+
+// end makes reads return err once the queued bytes are read. publish runs
+// under the lock of the queue, the reader sees its effects when it gets err.
+func (q *frameQueue) end(err error, publish func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.err == nil {
+		if publish != nil {
+			publish()
+		}
+		q.err = err
+	}
+	q.cond.Broadcast()
+}
+
+// conn is a hijacked connection, its bytes travel as frames through the
+// dev server.
+type conn struct {
+	out *frameWriter
+
+	remoteAddr addr
+
+	in *frameQueue
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newConn(out *frameWriter, remoteAddr string) *conn {
+	return &conn{
+		out:        out,
+		remoteAddr: addr(remoteAddr),
+		in:         newFrameQueue(),
+		closed:     make(chan struct{}),
+	}
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	return c.in.Read(b)
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+
+	default:
+	}
+
+	err := c.out.writeChunks(frameConnData, b)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *conn) Close() error {
+	c.once.Do(func() {
+		c.out.write(frameConnClose, nil)
+		c.in.Close()
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr { return addr("") }
+
+func (c *conn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+func (c *conn) SetDeadline(t time.Time) error { return nil }
 
+func (c *conn) SetReadDeadline(t time.Time) error { return nil }
+
+func (c *conn) SetWriteDeadline(t time.Time) error { return nil }
+
+type responseHead struct {
 	Headers    http.Header ` + "`json:\"headers\"`" + `
 	StatusCode int         ` + "`json:\"statusCode\"`" + `
 }
 
-func newResponseWriter() *responseWriter {
+type responseWriter struct {
+	out *frameWriter
+
+	header      http.Header
+	wroteHeader bool
+
+	conn     *conn
+	hijacked bool
+}
+
+func newResponseWriter(out *frameWriter, conn *conn) *responseWriter {
 	return &responseWriter{
-		Headers: make(http.Header),
+		out:    out,
+		header: make(http.Header),
+		conn:   conn,
 	}
 }
 
 func (w *responseWriter) Header() http.Header {
-	return w.Headers
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader || w.hijacked {
+		return
+	}
+	w.wroteHeader = true
+
+	headers := make(http.Header, len(w.header))
+	for key, values := range w.header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			continue
+		}
+		headers[key] = values
+	}
+
+	w.out.writeJSON(frameHead, responseHead{Headers: headers, StatusCode: statusCode})
 }
 
 func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.hijacked {
+		return 0, http.ErrHijacked
+	}
 	if !w.wroteHeader {
 		w.WriteHeader(http.StatusOK)
 	}
-	return os.Stdout.Write(b)
+
+	err := w.out.writeChunks(frameData, b)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
 }
 
-func (w *responseWriter) WriteHeader(statusCode int) {
-	w.wroteHeader = true
-	w.StatusCode = statusCode
-	json.NewEncoder(os.Stdout).Encode(w)
+func (w *responseWriter) Flush() {
+	if w.hijacked {
+		return
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.out.write(frameFlush, nil)
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, http.ErrHijacked
+	}
+	w.hijacked = true
+
+	err := w.out.write(frameHijack, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rw := bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn))
+	return w.conn, rw, nil
+}
+
+// finish sends the trailers and ends the response.
+func (w *responseWriter) finish() {
+	if w.hijacked {
+		return
+	}
+
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	trailer := make(http.Header)
+	for _, declared := range w.header.Values("Trailer") {
+		for _, key := range strings.Split(declared, ",") {
+			key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+			if values, ok := w.header[key]; ok {
+				trailer[key] = values
+			}
+		}
+	}
+	for key, values := range w.header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			trailer[strings.TrimPrefix(key, http.TrailerPrefix)] = values
+		}
+	}
+	if len(trailer) > 0 {
+		w.out.writeJSON(frameTrailer, trailer)
+	}
+
+	w.out.write(frameEnd, nil)
+}
+
+// captureLogs sends whatever is printed to stdout or through the log package
+// as log frames. The returned function waits until all of it was sent.
+func captureLogs(out *frameWriter) func() {
+	r, w, err := os.Pipe()
+	if err != nil {
+		os.Stdout = os.Stderr
+		return func() {}
+	}
+	os.Stdout = w
+	log.SetOutput(w)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, frameChunkSize)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				out.write(frameLog, buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		w.Close()
+		<-done
+	}
+}
+
+type handlerPanic struct {
+	Message string ` + "`json:\"message\"`" + `
+	Stack   string ` + "`json:\"stack\"`" + `
+}
+
+// serve runs the handler, recovering a panic so that it can be reported to
+// the dev server. A handler aborted with http.ErrAbortHandler isn't an error.
+func serve(h http.Handler, w http.ResponseWriter, r *http.Request) (recovered *handlerPanic, aborted bool) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+			aborted = true
+			return
+		}
+		recovered = &handlerPanic{
+			Message: fmt.Sprint(v),
+			Stack:   string(debug.Stack()),
+		}
+	}()
+
+	h.ServeHTTP(w, r)
+	return nil, false
+}
+
+// receive reads the frames sent by the dev server after the request head.
+// Trailers are only published to r once the body ends, handlers read them
+// after the body returned io.EOF.
+func receive(in io.Reader, r *http.Request, body *frameQueue, conn *conn, cancel context.CancelFunc) {
+	trailer := http.Header{}
+	for {
+		typ, payload, err := readFrame(in)
+		if err != nil {
+			// the dev server went away
+			body.end(io.ErrUnexpectedEOF, nil)
+			conn.in.end(io.EOF, nil)
+			cancel()
+			time.AfterFunc(cancelGracePeriod, func() {
+				os.Exit(1)
+			})
+			return
+		}
+
+		switch typ {
+		case frameData:
+			body.write(payload)
+
+		case frameTrailer:
+			var values http.Header
+			if json.Unmarshal(payload, &values) == nil {
+				for key, v := range values {
+					trailer[key] = v
+				}
+			}
+
+		case frameEnd:
+			body.end(io.EOF, func() {
+				if len(trailer) == 0 {
+					return
+				}
+				if r.Trailer == nil {
+					r.Trailer = make(http.Header)
+				}
+				for key, values := range trailer {
+					r.Trailer[key] = values
+				}
+			})
+
+		case frameCancel:
+			cancel()
+
+		case frameConnData:
+			conn.in.write(payload)
+
+		case frameConnClose:
+			conn.in.end(io.EOF, nil)
+		}
+	}
 }
 
 {{template "renderHandler" .}}
 
 func main() {
+	out := &frameWriter{w: os.Stdout}
+	// stdout carries the frames, anything printed by handlers becomes logs
+	flushLogs := captureLogs(out)
+
+	in := bufio.NewReader(os.Stdin)
+	typ, payload, err := readFrame(in)
+	if err != nil {
+		panic(err)
+	}
+	if typ != frameHead {
+		panic("nova: expected a request head")
+	}
+
 	var jsonReq request
-	json.NewDecoder(os.Stdin).Decode(&jsonReq)
-	r, err := transformRequest(&jsonReq)
+	err = json.Unmarshal(payload, &jsonReq)
 	if err != nil {
 		panic(err)
 	}
 
-	w := newResponseWriter()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	body := newFrameQueue()
+	r, err := transformRequest(ctx, &jsonReq, body)
+	if err != nil {
+		panic(err)
+	}
+
+	conn := newConn(out, jsonReq.RemoteAddr)
+	w := newResponseWriter(out, conn)
+
+	go receive(in, r, body, conn, cancel)
 
 	mux := http.NewServeMux()
 	{{with $handler := .Handler}}
 	{{with $render := .Render}}mux.Handle("{{$render.Pattern}}", renderHandler("{{$render.Root}}", []string{ {{- range $render.Templates}}"{{.}}", {{end -}} }, {{$handler.Package}}.{{$render.Handler}})){{end}}
-	{{range .Rest}}mux.HandleFunc("{{.Pattern}}", {{$handler.Package}}.{{.Handler}}){{end}}
+	{{range .Rest}}mux.HandleFunc("{{.Pattern}}", {{$handler.Package}}.{{.Handler}})
 	{{end}}
+	{{end}}
+
+	// keep the match of the dev server, including its path values
+	h, _ := mux.Handler(r)
+	r.Pattern = jsonReq.Pattern
+	for name, value := range jsonReq.PathValues {
+		r.SetPathValue(name, value)
+	}
+
+	recovered, aborted := serve(h, w, r)
+	if w.hijacked && recovered == nil {
+		// the connection is kept until the handler closes it
+		<-conn.closed
+	}
+	flushLogs()
+
+	switch {
+	case recovered != nil:
+		out.writeJSON(frameError, recovered)
 
-	mux.ServeHTTP(w, r)
+	case aborted:
+
+	default:
+		w.finish()
+	}
 }
 `
 
@@ -148,12 +661,14 @@ type routeModuleHandler struct {
 	Package string
 }
 
-func (c *Codegen) GenerateRouteModule(filename string, routes []router.Route) error {
+// GenerateRouteModule writes the dev entry point of a route file and returns
+// the path of the generated main.go.
+func (c *Codegen) GenerateRouteModule(filename string, routes []router.Route) (string, error) {
 	pagespath := module.Abs(c.config.Router.Src)
 
 	targetpath, err := filepath.Rel(pagespath, filepath.Dir(filename))
 	if err != nil {
-		return err
+		return "", err
 	}
 	targetpath = module.Join(c.config.Codegen.OutDir, "pages", targetpath)
 	target := filepath.Join(targetpath, "main.go")
@@ -179,7 +694,7 @@ func (c *Codegen) GenerateRouteModule(filename string, routes []router.Route) er
 	os.MkdirAll(targetpath, 0755)
 	file, err := os.Create(target)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer file.Close()
 
@@ -190,8 +705,8 @@ func (c *Codegen) GenerateRouteModule(filename string, routes []router.Route) er
 		"Handler": handler,
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return nil
+	return target, nil
 }
@@ -2,6 +2,7 @@ package config
 
 type Config struct {
 	Codegen CodegenConfig `json:"codegen"`
+	ESBuild ESBuildConfig `json:"esbuild"`
 	Router  RouterConfig  `json:"router"`
 	Server  ServerConfig  `json:"server"`
 	Watcher WatcherConfig `json:"watcher"`
@@ -10,6 +11,7 @@ type Config struct {
 func Default() Config {
 	return Config{
 		Codegen: defaultCodegenConfig(),
+		ESBuild: defaultESBuildConfig(),
 		Router:  defaultRouterConfig(),
 		Server:  defaultServerConfig(),
 		Watcher: defaultWatcherConfig(),
@@ -18,6 +20,7 @@ func Default() Config {
 
 func (cfg *Config) Merge(other *Config) {
 	cfg.Codegen.merge(&other.Codegen)
+	cfg.ESBuild.merge(&other.ESBuild)
 	cfg.Router.merge(&other.Router)
 	cfg.Server.merge(&other.Server)
 	cfg.Watcher.merge(&other.Watcher)
@@ -0,0 +1,47 @@
+package config
+
+const (
+	JSXAutomatic = "automatic" // imports the JSX functions from importSource
+	JSXClassic   = "classic"   // calls factory and fragment, they must be in scope
+)
+
+type JSXConfig struct {
+	Runtime      string `json:"runtime"`      // it defaults to "automatic"
+	ImportSource string `json:"importSource"` // package of the automatic runtime, i.e. "preact", it defaults to "react"
+	Factory      string `json:"factory"`      // classic runtime element factory, it defaults to "React.createElement"
+	Fragment     string `json:"fragment"`     // classic runtime fragment, it defaults to "React.Fragment"
+}
+
+type ESBuildConfig struct {
+	JSX JSXConfig `json:"jsx"`
+}
+
+func defaultESBuildConfig() ESBuildConfig {
+	return ESBuildConfig{
+		JSX: JSXConfig{
+			Runtime: JSXAutomatic,
+		},
+	}
+}
+
+func (cfg *ESBuildConfig) merge(other *ESBuildConfig) {
+	cfg.JSX.merge(&other.JSX)
+}
+
+func (cfg *JSXConfig) merge(other *JSXConfig) {
+	if other.Runtime != "" {
+		cfg.Runtime = other.Runtime
+	}
+
+	if other.ImportSource != "" {
+		cfg.ImportSource = other.ImportSource
+	}
+
+	if other.Factory != "" {
+		cfg.Factory = other.Factory
+	}
+
+	if other.Fragment != "" {
+		cfg.Fragment = other.Fragment
+	}
+}
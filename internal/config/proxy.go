@@ -0,0 +1,25 @@
+package config
+
+import "encoding/json"
+
+// ProxyConfig forwards a path prefix of the dev server to another backend.
+// In the config file it is either an object or just the target URL.
+type ProxyConfig struct {
+	Target       string            `json:"target"`       // upstream URL, i.e. "http://localhost:9000"
+	Rewrite      map[string]string `json:"rewrite"`      // path regexp to replacement, applied in key order
+	Headers      map[string]string `json:"headers"`      // headers set on every upstream request
+	WS           bool              `json:"ws"`           // allow WebSocket upgrades
+	ChangeOrigin bool              `json:"changeOrigin"` // send the target as Host and Origin
+}
+
+func (cfg *ProxyConfig) UnmarshalJSON(b []byte) error {
+	var target string
+	if err := json.Unmarshal(b, &target); err == nil {
+		*cfg = ProxyConfig{Target: target}
+		return nil
+	}
+
+	// the alias drops UnmarshalJSON so decoding doesn't recurse
+	type proxyConfig ProxyConfig
+	return json.Unmarshal(b, (*proxyConfig)(cfg))
+}
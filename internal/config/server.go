@@ -1,14 +1,28 @@
 package config
 
+const (
+	RuntimeModule = "module" // one `go run` process per route request
+	RuntimeApp    = "app"    // a single hot-restarting application behind a reverse proxy
+)
+
 type ServerConfig struct {
-	Host string `json:"host"`
-	Port uint16 `json:"port"`
+	Host    string `json:"host"`
+	Port    uint16 `json:"port"`
+	Runtime string `json:"runtime"` // dev runtime for go routes, it defaults to "module"
+	HTTPS   bool   `json:"https"`   // serve dev over https with a locally trusted certificate
+
+	StrictPort bool `json:"strictPort"` // fail when port is busy instead of trying the next ones
+
+	Proxy map[string]ProxyConfig `json:"proxy"` // path prefix to upstream, forwarded ahead of nova routes
+
+	AllowedHosts []string `json:"allowedHosts"` // other hostnames browsers reach the dev server by, like a tunnel
 }
 
 func defaultServerConfig() ServerConfig {
 	return ServerConfig{
-		Host: "localhost",
-		Port: 8080,
+		Host:    "localhost",
+		Port:    8080,
+		Runtime: RuntimeModule,
 	}
 }
 
@@ -20,4 +34,24 @@ func (cfg *ServerConfig) merge(other *ServerConfig) {
 	if other.Port != 0 {
 		cfg.Port = other.Port
 	}
+
+	if other.Runtime != "" {
+		cfg.Runtime = other.Runtime
+	}
+
+	if other.HTTPS {
+		cfg.HTTPS = true
+	}
+
+	if other.StrictPort {
+		cfg.StrictPort = true
+	}
+
+	if len(other.Proxy) > 0 {
+		cfg.Proxy = other.Proxy
+	}
+
+	if len(other.AllowedHosts) > 0 {
+		cfg.AllowedHosts = other.AllowedHosts
+	}
 }
@@ -1,18 +1,32 @@
 package config
 
+const (
+	WatcherAuto    = "auto"    // inotify where available, polling elsewhere
+	WatcherInotify = "inotify" // fails where inotify is unavailable
+	WatcherPoll    = "poll"    // walks the watched directories every discovery interval
+)
+
 type WatcherConfig struct {
-	Discovery int `json:"discovery"`
-	Sync      int `json:"sync"`
+	Backend   string `json:"backend"`   // how file changes are detected, it defaults to "auto"
+	Discovery int    `json:"discovery"` // polling interval in milliseconds
+	Sync      int    `json:"sync"`
+
+	Ignore []string `json:"ignore"` // extra .gitignore style rules, relative to the module root, over the ones of the .gitignore files
 }
 
 func defaultWatcherConfig() WatcherConfig {
 	return WatcherConfig{
+		Backend:   WatcherAuto,
 		Discovery: 250,
 		Sync:      500,
 	}
 }
 
 func (cfg *WatcherConfig) merge(other *WatcherConfig) {
+	if other.Backend != "" {
+		cfg.Backend = other.Backend
+	}
+
 	if other.Discovery != 0 {
 		cfg.Discovery = other.Discovery
 	}
@@ -20,4 +34,8 @@ func (cfg *WatcherConfig) merge(other *WatcherConfig) {
 	if other.Sync != 0 {
 		cfg.Sync = other.Sync
 	}
+
+	if len(other.Ignore) > 0 {
+		cfg.Ignore = other.Ignore
+	}
 }
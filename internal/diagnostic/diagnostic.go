@@ -0,0 +1,136 @@
+package diagnostic
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	SourceESBuild  = "esbuild"
+	SourceGo       = "go"
+	SourceCodegen  = "codegen"
+	SourceTemplate = "template"
+	SourceRuntime  = "runtime"
+	SourceProxy    = "proxy"
+	SourceWatcher  = "watcher"
+)
+
+type Diagnostic struct {
+	Source  string `json:"source"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+	Frame   string `json:"frame"`
+}
+
+func (d Diagnostic) String() string {
+	switch {
+	case d.File == "":
+		return d.Message
+
+	case d.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+
+	default:
+		return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+	}
+}
+
+const frameContext = 2
+
+// Frame renders the lines around line from filename, marking the offending
+// line and column. It returns an empty string if the file can't be read.
+func Frame(filename string, line, column int) string {
+	if filename == "" || line < 1 {
+		return ""
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return ""
+	}
+
+	lines := strings.Split(string(b), "\n")
+	if line > len(lines) {
+		return ""
+	}
+
+	start := max(line-frameContext, 1)
+	end := min(line+frameContext, len(lines))
+	width := len(strconv.Itoa(end))
+
+	var frame strings.Builder
+	for i := start; i <= end; i++ {
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&frame, "%s %*d | %s\n", marker, width, i, lines[i-1])
+		if i == line && column > 0 {
+			fmt.Fprintf(&frame, "  %s | %s^\n", strings.Repeat(" ", width), strings.Repeat(" ", column-1))
+		}
+	}
+
+	return frame.String()
+}
+
+var (
+	goErrorRe       = regexp.MustCompile(`^(.+\.go):(\d+):(?:(\d+):)? (.+)$`)
+	templateErrorRe = regexp.MustCompile(`template: ([^:]+):(\d+):(?:(\d+):)? (.+)$`)
+)
+
+// ParseGo extracts diagnostics from the output of the go toolchain (compiler
+// errors) and from html/template panics. Relative paths are resolved against
+// dir. Output that doesn't look like a diagnostic is reported as a single
+// message so that nothing is lost.
+func ParseGo(dir string, out []byte) []Diagnostic {
+	diagnostics := []Diagnostic{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if m := templateErrorRe.FindStringSubmatch(line); m != nil {
+			diagnostics = append(diagnostics, newDiagnostic(SourceTemplate, dir, m))
+			continue
+		}
+
+		if m := goErrorRe.FindStringSubmatch(line); m != nil {
+			diagnostics = append(diagnostics, newDiagnostic(SourceGo, dir, m))
+		}
+	}
+
+	if len(diagnostics) == 0 && len(bytes.TrimSpace(out)) > 0 {
+		diagnostics = append(diagnostics, Diagnostic{
+			Source:  SourceGo,
+			Message: string(bytes.TrimSpace(out)),
+		})
+	}
+
+	return diagnostics
+}
+
+func newDiagnostic(source string, dir string, m []string) Diagnostic {
+	filename := m[1]
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+	line, _ := strconv.Atoi(m[2])
+	column, _ := strconv.Atoi(m[3])
+
+	return Diagnostic{
+		Source:  source,
+		File:    m[1],
+		Line:    line,
+		Column:  column,
+		Message: m[4],
+		Frame:   Frame(filename, line, column),
+	}
+}
@@ -0,0 +1,146 @@
+// Package env loads the .env files of a project.
+package env
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sgq995/nova/internal/module"
+)
+
+// PublicPrefix marks the variables that client code may read through
+// import.meta.env, the others stay on the server.
+const PublicPrefix = "NOVA_PUBLIC_"
+
+// Files lists the env files of mode, like "development" or "production",
+// lowest precedence first.
+func Files(mode string) []string {
+	return []string{".env", ".env.local", ".env." + mode, ".env." + mode + ".local"}
+}
+
+// Load reads the env files of mode at the module root, a variable of a file
+// overrides the ones of the files before it. Missing files are skipped.
+func Load(mode string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, name := range Files(mode) {
+		err := parseFile(module.Abs(name), vars)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return vars, nil
+}
+
+// Apply sets the variables that the process environment lacks, so it keeps
+// precedence over env files, and returns the ones it set.
+func Apply(vars map[string]string) map[string]string {
+	applied := map[string]string{}
+	for key, value := range vars {
+		if _, exists := os.LookupEnv(key); exists {
+			continue
+		}
+		os.Setenv(key, value)
+		applied[key] = value
+	}
+	return applied
+}
+
+// IsPublic reports whether the variable key may reach client code.
+func IsPublic(key string) bool {
+	return strings.HasPrefix(key, PublicPrefix)
+}
+
+// Public returns the public variables of the process environment.
+func Public() map[string]string {
+	public := map[string]string{}
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if IsPublic(key) {
+			public[key] = value
+		}
+	}
+	return public
+}
+
+// parseFile adds the variables of filename to vars. Lines are KEY=VALUE,
+// optionally prefixed by "export". Values may be quoted, double quotes
+// understand Go escapes, and unquoted ones end at " #".
+func parseFile(filename string, vars map[string]string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" || strings.ContainsAny(key, " \t") {
+			return fmt.Errorf("nova: %s:%d: expected KEY=VALUE", module.Rel(filename), n)
+		}
+
+		value, err = parseValue(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("nova: %s:%d: %s: %w", module.Rel(filename), n, key, err)
+		}
+		vars[key] = value
+	}
+
+	return scanner.Err()
+}
+
+func parseValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := closingQuote(value)
+		if end == -1 {
+			return "", errors.New("unterminated quote")
+		}
+		return strconv.Unquote(value[:end+1])
+
+	case strings.HasPrefix(value, "'"):
+		end := strings.Index(value[1:], "'")
+		if end == -1 {
+			return "", errors.New("unterminated quote")
+		}
+		return value[1 : end+1], nil
+
+	default:
+		if i := strings.Index(value, " #"); i != -1 {
+			value = value[:i]
+		}
+		return strings.TrimSpace(value), nil
+	}
+}
+
+// closingQuote returns the index of the double quote closing value, skipping
+// escaped ones, or -1.
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
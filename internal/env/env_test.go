@@ -0,0 +1,163 @@
+package env
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		vars     map[string]string
+		err      bool
+	}{
+		{
+			name:     "plain",
+			contents: "A=1\nB=two words\n",
+			vars:     map[string]string{"A": "1", "B": "two words"},
+		},
+		{
+			name:     "comments and blank lines",
+			contents: "# comment\n\n   \nA=1\n  # indented comment\n",
+			vars:     map[string]string{"A": "1"},
+		},
+		{
+			name:     "spaces around",
+			contents: "  A = 1  \n",
+			vars:     map[string]string{"A": "1"},
+		},
+		{
+			name:     "export",
+			contents: "export A=1\n",
+			vars:     map[string]string{"A": "1"},
+		},
+		{
+			name:     "empty value",
+			contents: "A=\n",
+			vars:     map[string]string{"A": ""},
+		},
+		{
+			name:     "value with equals",
+			contents: "URL=postgres://u:p@host/db?sslmode=disable&a=b\n",
+			vars:     map[string]string{"URL": "postgres://u:p@host/db?sslmode=disable&a=b"},
+		},
+		{
+			name:     "inline comment",
+			contents: "A=1 # the first\nB=color#fff\n",
+			vars:     map[string]string{"A": "1", "B": "color#fff"},
+		},
+		{
+			name:     "double quotes",
+			contents: `A="line\nbreak \"quoted\" # kept" # comment` + "\n",
+			vars:     map[string]string{"A": "line\nbreak \"quoted\" # kept"},
+		},
+		{
+			name:     "single quotes",
+			contents: `A='no \n escapes # kept' # comment` + "\n",
+			vars:     map[string]string{"A": `no \n escapes # kept`},
+		},
+		{
+			name:     "later lines win",
+			contents: "A=1\nA=2\n",
+			vars:     map[string]string{"A": "2"},
+		},
+		{
+			name:     "windows line endings",
+			contents: "A=1\r\nB=2\r\n",
+			vars:     map[string]string{"A": "1", "B": "2"},
+		},
+		{
+			name:     "missing equals",
+			contents: "A\n",
+			err:      true,
+		},
+		{
+			name:     "missing key",
+			contents: "=1\n",
+			err:      true,
+		},
+		{
+			name:     "space in key",
+			contents: "MY KEY=1\n",
+			err:      true,
+		},
+		{
+			name:     "unterminated double quote",
+			contents: `A="open` + "\n",
+			err:      true,
+		},
+		{
+			name:     "unterminated single quote",
+			contents: "A='open\n",
+			err:      true,
+		},
+		{
+			name:     "invalid escape",
+			contents: `A="\q"` + "\n",
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), ".env")
+			if err := os.WriteFile(filename, []byte(tt.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			vars := map[string]string{}
+			err := parseFile(filename, vars)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("parseFile succeeded with %q, want an error", vars)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFile: %v", err)
+			}
+			if !maps.Equal(vars, tt.vars) {
+				t.Errorf("parseFile = %q, want %q", vars, tt.vars)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	t.Setenv("NOVA_TEST_SET", "process")
+	os.Unsetenv("NOVA_TEST_UNSET")
+	t.Cleanup(func() { os.Unsetenv("NOVA_TEST_UNSET") })
+
+	applied := Apply(map[string]string{
+		"NOVA_TEST_SET":   "file",
+		"NOVA_TEST_UNSET": "file",
+	})
+
+	if want := map[string]string{"NOVA_TEST_UNSET": "file"}; !maps.Equal(applied, want) {
+		t.Errorf("Apply = %q, want %q", applied, want)
+	}
+	if got := os.Getenv("NOVA_TEST_SET"); got != "process" {
+		t.Errorf("NOVA_TEST_SET = %q, the process environment must win", got)
+	}
+	if got := os.Getenv("NOVA_TEST_UNSET"); got != "file" {
+		t.Errorf("NOVA_TEST_UNSET = %q, want %q", got, "file")
+	}
+}
+
+func TestPublic(t *testing.T) {
+	t.Setenv(PublicPrefix+"TEST_URL", "https://example.com")
+	t.Setenv("NOVA_TEST_SECRET", "secret")
+
+	public := Public()
+	if public[PublicPrefix+"TEST_URL"] != "https://example.com" {
+		t.Errorf("Public() lacks %sTEST_URL", PublicPrefix)
+	}
+	for key := range public {
+		if !IsPublic(key) {
+			t.Errorf("Public() returned %s", key)
+		}
+	}
+}
@@ -29,6 +29,7 @@ func NewESBuild(c *config.Config) *ESBuild {
 type BuildOptions struct {
 	EntryPoints []string
 	Outdir      string
+	Outbase     string // keeps output paths stable, whatever the entry points
 	EntryMap    map[string]string
 	Hashing     bool
 }
@@ -41,7 +42,7 @@ func (esbuild *ESBuild) Build(options BuildOptions) (map[string]string, error) {
 	}
 
 	fsys.Clean(options.Outdir)
-	result := api.Build(api.BuildOptions{
+	buildOptions := api.BuildOptions{
 		EntryPoints:       options.EntryPoints,
 		EntryNames:        entryNames,
 		Bundle:            true,
@@ -50,6 +51,7 @@ func (esbuild *ESBuild) Build(options BuildOptions) (map[string]string, error) {
 		Format:            api.FormatESModule,
 		Splitting:         true,
 		Outdir:            options.Outdir,
+		Outbase:           options.Outbase,
 		MinifyWhitespace:  true,
 		MinifyIdentifiers: true,
 		MinifySyntax:      true,
@@ -139,13 +141,24 @@ func (esbuild *ESBuild) Build(options BuildOptions) (map[string]string, error) {
 				},
 			},
 		},
-	})
+	}
+	err := applyJSX(&buildOptions, &esbuild.config.ESBuild.JSX, false)
+	if err != nil {
+		return nil, err
+	}
+	err = applyEnv(&buildOptions)
+	if err != nil {
+		return nil, err
+	}
+	applyPlugins(&buildOptions, false)
+
+	result := api.Build(buildOptions)
 	if len(result.Errors) > 0 {
 		return nil, esbuildError(result.Errors)
 	}
 
 	meta := make(map[string]any)
-	err := json.Unmarshal([]byte(result.Metafile), &meta)
+	err = json.Unmarshal([]byte(result.Metafile), &meta)
 	if err != nil {
 		return nil, err
 	}
@@ -1,28 +1,79 @@
 package esbuild
 
 import (
+	"bytes"
 	"errors"
+	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/evanw/esbuild/pkg/api"
 	"github.com/sgq995/nova/internal/config"
+	"github.com/sgq995/nova/internal/diagnostic"
 	"github.com/sgq995/nova/internal/logger"
 	"github.com/sgq995/nova/internal/module"
 )
 
+// hmrBanner is prepended to every dev output, it loads the hmr client and
+// exposes the import.meta.hot API of the module.
+const hmrBanner = `import { createHotContext as __nova_createHotContext } from "/@nova/hmr.js";
+import.meta.hot = ` + hotContextCall + `;`
+
+const hotContextCall = "__nova_createHotContext(import.meta.url)"
+
+// linkStyle hands the stylesheet of the CSS a module imports to its hot
+// context, which links it to the page. Only the banner line changes, the
+// source map of the module stays valid.
+func linkStyle(contents []byte, style string) []byte {
+	call := "__nova_createHotContext(import.meta.url, " + strconv.Quote(style) + ")"
+	return bytes.Replace(contents, []byte(hotContextCall), []byte(call), 1)
+}
+
+// moduleExtensions are the sources built on their own in dev, see moduleURL.
+var moduleExtensions = []string{".js", ".ts", ".jsx", ".tsx", ".mjs", ".mts"}
+
+// resolvingModule marks the resolutions nova-modules asks esbuild for.
+type resolvingModule struct{}
+
+// moduleURL returns the URL that serves filename in dev, or false when it is
+// bundled into its importers instead. Scripts under router.src are built on
+// their own and imported by URL, hot updates evaluate them again one by one.
+func moduleURL(src string, filename string) (string, bool) {
+	rel, err := filepath.Rel(src, filename)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+
+	ext := filepath.Ext(rel)
+	if !slices.Contains(moduleExtensions, ext) || slices.Contains(strings.Split(filepath.ToSlash(rel), "/"), "node_modules") {
+		return "", false
+	}
+
+	return "/" + filepath.ToSlash(strings.TrimSuffix(rel, ext)) + ".js", true
+}
+
 type ESBuildContext struct {
 	config *config.Config
 
-	mu  sync.Mutex
-	app api.BuildContext
+	mu          sync.Mutex
+	app         api.BuildContext
+	generation  int
+	entryPoints []string
+	modules     []string // imported by the entry points, see moduleURL
+	versions    *moduleVersions
+	onEnd       func(result *DevResult) error
 	// nodeModules api.BuildContext
 }
 
 func NewESBuildContext(c *config.Config) *ESBuildContext {
 	return &ESBuildContext{
-		config: c,
+		config:   c,
+		versions: newModuleVersions(),
 	}
 }
 
@@ -82,8 +133,14 @@ func (ctx *ESBuildContext) Watch() error {
 }
 
 func (ctx *ESBuildContext) Dispose() {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
 	// ctx.nodeModules.Dispose()
-	ctx.app.Dispose()
+	if ctx.app != nil {
+		ctx.app.Dispose()
+		ctx.app = nil
+	}
 }
 
 // func (ctx *ESBuildContext) Define(entryPoints []string) error {
@@ -198,20 +255,84 @@ func (ctx *ESBuildContext) Dispose() {
 // 	return nil
 // }
 
-func (ctx *ESBuildContext) Start(entryPoints []string, onEnd func(files map[string][]byte) error) error {
+// DevResult is handed to the Start callback after every build. Files are
+// keyed by their slash-separated path relative to the output directory.
+// Changed lists the scripts whose own code changed since the previous build,
+// the other scripts that differ only import fresh copies of them.
+type DevResult struct {
+	Files       map[string][]byte
+	Graph       *ModuleGraph
+	Changed     []string
+	Diagnostics []diagnostic.Diagnostic
+}
+
+func (ctx *ESBuildContext) Start(entryPoints []string, onEnd func(result *DevResult) error) error {
 	ctx.mu.Lock()
 	defer ctx.mu.Unlock()
 
+	ctx.onEnd = onEnd
+	return ctx.watchLocked(entryPoints, nil)
+}
+
+// SetEntryPoints replaces the entry points of a started context. esbuild
+// can't change them in place, so the context is recreated; its first build
+// hands the whole new set of outputs to onEnd, which drops the stale ones.
+func (ctx *ESBuildContext) SetEntryPoints(entryPoints []string) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if slices.Equal(ctx.entryPoints, entryPoints) {
+		return nil
+	}
+
+	if ctx.app != nil {
+		ctx.app.Dispose()
+		ctx.app = nil
+	}
+	return ctx.watchLocked(entryPoints, ctx.modules)
+}
+
+// setModules recreates the context of generation to build modules too, a
+// build finds them as it resolves the imports of the previous ones.
+func (ctx *ESBuildContext) setModules(generation int, modules []string) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if ctx.generation != generation || ctx.app == nil {
+		return
+	}
+
+	ctx.app.Dispose()
+	ctx.app = nil
+	if err := ctx.watchLocked(ctx.entryPoints, modules); err != nil {
+		logger.Errorf("%+v", err)
+	}
+}
+
+func (ctx *ESBuildContext) watchLocked(entryPoints []string, modules []string) error {
+	onEnd := ctx.onEnd
+	ctx.generation++
+	generation := ctx.generation
+
+	src := module.Abs(ctx.config.Router.Src)
+	var foundMu sync.Mutex
+	found := map[string]struct{}{}
+
 	outDir := module.Join(ctx.config.Codegen.OutDir, "static")
-	appCtx, ctxErr := api.Context(api.BuildOptions{
-		EntryPoints: entryPoints,
+	entries := slices.Concat(entryPoints, modules)
+	slices.Sort(entries)
+
+	buildOptions := api.BuildOptions{
+		EntryPoints: slices.Compact(entries),
 		Outdir:      outDir,
+		Outbase:     src, // outputs keep their URL as entry points change
 		Format:      api.FormatESModule,
 		Bundle:      true,
 		Splitting:   true,
+		Metafile:    true,
 		Sourcemap:   api.SourceMapInline,
 		Banner: map[string]string{
-			"js": `import "/@nova/hmr.js";`,
+			"js": hmrBanner,
 		},
 		Plugins: []api.Plugin{
 			{
@@ -220,10 +341,11 @@ func (ctx *ESBuildContext) Start(entryPoints []string, onEnd func(files map[stri
 					nodeModules := map[string]string{}
 
 					pb.OnResolve(api.OnResolveOptions{Filter: "^[^./]"}, func(ora api.OnResolveArgs) (api.OnResolveResult, error) {
-						packagepath := module.Join("node_modules", ora.Path)
+						// subpaths like "preact/jsx-dev-runtime" are resolved by the package
+						packagepath := module.Join("node_modules", packageName(ora.Path))
 						_, err := os.Stat(packagepath)
 						switch {
-						case err == os.ErrNotExist:
+						case errors.Is(err, fs.ErrNotExist):
 							return api.OnResolveResult{}, nil
 
 						case err != nil:
@@ -268,25 +390,116 @@ func (ctx *ESBuildContext) Start(entryPoints []string, onEnd func(files map[stri
 					})
 				},
 			},
+			{
+				Name: "nova-modules",
+				Setup: func(pb api.PluginBuild) {
+					pb.OnStart(func() (api.OnStartResult, error) {
+						foundMu.Lock()
+						defer foundMu.Unlock()
+
+						clear(found)
+						return api.OnStartResult{}, nil
+					})
+
+					pb.OnResolve(api.OnResolveOptions{Filter: `^\.{0,2}/`, Namespace: "file"}, func(ora api.OnResolveArgs) (api.OnResolveResult, error) {
+						if ora.PluginData == (resolvingModule{}) {
+							return api.OnResolveResult{}, nil
+						}
+						if ora.Kind != api.ResolveJSImportStatement && ora.Kind != api.ResolveJSDynamicImport {
+							return api.OnResolveResult{}, nil
+						}
+
+						result := pb.Resolve(ora.Path, api.ResolveOptions{
+							ResolveDir: ora.ResolveDir,
+							Importer:   ora.Importer,
+							Namespace:  ora.Namespace,
+							With:       ora.With,
+							Kind:       ora.Kind,
+							PluginData: resolvingModule{},
+						})
+						if len(result.Errors) > 0 || result.Namespace != "file" || result.Suffix != "" {
+							return api.OnResolveResult{}, nil
+						}
+						url, ok := moduleURL(src, result.Path)
+						if !ok {
+							return api.OnResolveResult{}, nil
+						}
+
+						foundMu.Lock()
+						found[result.Path] = struct{}{}
+						foundMu.Unlock()
+
+						return api.OnResolveResult{
+							External: true,
+							Path:     url,
+						}, nil
+					})
+				},
+			},
 			{
 				Name: "nova-callback",
 				Setup: func(pb api.PluginBuild) {
 					pb.OnEnd(func(result *api.BuildResult) (api.OnEndResult, error) {
+						// esbuild notices a removed entry point before nova
+						// replaces the context, that failure is not news
+						if len(result.Errors) > 0 && slices.ContainsFunc(entryPoints, missing) {
+							return api.OnEndResult{}, nil
+						}
+
+						// the modules found are built by a new context, a
+						// removed one is dropped from it
+						if len(result.Errors) > 0 && slices.ContainsFunc(modules, missing) {
+							go ctx.setModules(generation, slices.DeleteFunc(slices.Clone(modules), missing))
+							return api.OnEndResult{}, nil
+						}
+						foundMu.Lock()
+						next := slices.Sorted(maps.Keys(found))
+						foundMu.Unlock()
+						if len(result.Errors) == 0 && !slices.Equal(next, modules) {
+							go ctx.setModules(generation, next)
+							return api.OnEndResult{}, nil
+						}
+
 						files := map[string][]byte{}
 						for _, file := range result.OutputFiles {
 							filename, err := filepath.Rel(outDir, file.Path)
 							if err != nil {
 								return api.OnEndResult{}, err
 							}
-							files[filename] = file.Contents
+							files[filepath.ToSlash(filename)] = file.Contents
+						}
+						graph, err := parseModuleGraph(outDir, result.Metafile)
+						if err != nil {
+							return api.OnEndResult{}, err
+						}
+						changed := []string{}
+						if len(result.Errors) == 0 {
+							changed = ctx.versions.update(files, graph)
+						}
+						err = onEnd(&DevResult{
+							Files:       files,
+							Graph:       graph,
+							Changed:     changed,
+							Diagnostics: esbuildDiagnostics(result.Errors),
+						})
+						if err != nil {
+							logger.Errorf("%+v", err)
 						}
-						onEnd(files)
 						return api.OnEndResult{}, nil
 					})
 				},
 			},
 		},
-	})
+	}
+	if err := applyJSX(&buildOptions, &ctx.config.ESBuild.JSX, true); err != nil {
+		return err
+	}
+	if err := applyEnv(&buildOptions); err != nil {
+		return err
+	}
+	applyPlugins(&buildOptions, true)
+
+	appCtx, ctxErr := api.Context(buildOptions)
 	if ctxErr != nil {
 		return ctxErr
 	}
@@ -298,6 +511,22 @@ func (ctx *ESBuildContext) Start(entryPoints []string, onEnd func(files map[stri
 	}
 
 	ctx.app = appCtx
+	ctx.entryPoints = slices.Clone(entryPoints)
+	ctx.modules = modules
 
 	return nil
 }
+
+func missing(filename string) bool {
+	_, err := os.Stat(filename)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// packageName returns the package of a bare import, scoped ones included.
+func packageName(importPath string) string {
+	parts := strings.SplitN(importPath, "/", 3)
+	if strings.HasPrefix(importPath, "@") && len(parts) > 1 {
+		return parts[0] + "/" + parts[1]
+	}
+	return parts[0]
+}
@@ -0,0 +1,204 @@
+package esbuild
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/sgq995/nova/internal/env"
+	"github.com/sgq995/nova/internal/module"
+)
+
+// envSentinel stands for import.meta.env while looking for the variables a
+// module reads, esbuild replaces it in code only, never in comments or strings.
+const envSentinel = "__nova_import_meta_env__"
+
+var (
+	envSentinelReference = regexp.MustCompile(`\b` + envSentinel + `\.([A-Za-z_$][\w$]*)`)
+	// esbuild turns ["KEY"] into .KEY, names that aren't identifiers stay
+	envSentinelIndex   = regexp.MustCompile(`\b` + envSentinel + `\[\s*(?:"([^"\\]*)"\s*\])?`)
+	envSentinelPattern = regexp.MustCompile(`\{([^{}]*)\}\s*=\s*` + envSentinel + `\b`)
+)
+
+// envReference is a variable read from import.meta.env at offset of the
+// transformed code, key is empty when it is computed at run time.
+type envReference struct {
+	offset int
+	key    string
+}
+
+// envReferences lists the variables code reads from the sentinel by name,
+// by destructuring or by a computed name, in order. Using the object as a
+// whole only reads public variables.
+func envReferences(code []byte) []envReference {
+	references := []envReference{}
+	for _, match := range envSentinelReference.FindAllSubmatchIndex(code, -1) {
+		references = append(references, envReference{match[0], string(code[match[2]:match[3]])})
+	}
+
+	for _, match := range envSentinelIndex.FindAllSubmatchIndex(code, -1) {
+		key := ""
+		if match[2] != -1 {
+			key = string(code[match[2]:match[3]])
+		}
+		references = append(references, envReference{match[0], key})
+	}
+
+	for _, match := range envSentinelPattern.FindAllSubmatchIndex(code, -1) {
+		for _, property := range strings.Split(string(code[match[2]:match[3]]), ",") {
+			name, _, _ := strings.Cut(property, ":")
+			name, _, _ = strings.Cut(name, "=")
+			name = strings.TrimSpace(name)
+			if name == "" || strings.HasPrefix(name, "...") {
+				continue
+			}
+
+			key := ""
+			if unquoted, err := strconv.Unquote(strings.TrimSpace(strings.Trim(name, "[]"))); err == nil {
+				key = unquoted
+			} else if !strings.HasPrefix(name, "[") {
+				key = name
+			}
+			references = append(references, envReference{match[0], key})
+		}
+	}
+
+	slices.SortStableFunc(references, func(a, b envReference) int {
+		return a.offset - b.offset
+	})
+	return references
+}
+
+// applyEnv inlines the public variables as import.meta.env, the others are
+// never defined so they can't end up in a bundle. Sources referencing them
+// fail the build, node_modules are left alone.
+func applyEnv(options *api.BuildOptions) error {
+	public := env.Public()
+
+	object, err := json.Marshal(public)
+	if err != nil {
+		return err
+	}
+
+	if options.Define == nil {
+		options.Define = map[string]string{}
+	}
+	options.Define["import.meta.env"] = string(object)
+	for key, value := range public {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		options.Define["import.meta.env."+key] = string(b)
+	}
+
+	options.Plugins = append(options.Plugins, api.Plugin{
+		Name: "nova-env",
+		Setup: func(pb api.PluginBuild) {
+			pb.OnLoad(api.OnLoadOptions{Filter: `\.[cm]?[jt]sx?$`, Namespace: "file"}, func(ola api.OnLoadArgs) (api.OnLoadResult, error) {
+				if strings.Contains(filepath.ToSlash(ola.Path), "/node_modules/") {
+					return api.OnLoadResult{}, nil
+				}
+
+				b, err := os.ReadFile(ola.Path)
+				if err != nil {
+					return api.OnLoadResult{}, err
+				}
+
+				// an empty result lets esbuild load the file itself
+				return api.OnLoadResult{Errors: privateReferences(ola.Path, b)}, nil
+			})
+		},
+	})
+
+	return nil
+}
+
+// privateReferences reports the non-public variables read by source, and
+// the ones read by a name computed at run time, which can't be checked. The
+// references are found in the code esbuild parsed, their location is the
+// first matching text of source.
+func privateReferences(filename string, source []byte) []api.Message {
+	result := api.Transform(string(source), api.TransformOptions{
+		Loader:        sourceLoader(filename),
+		Sourcefile:    filename,
+		Define:        map[string]string{"import.meta.env": envSentinel},
+		MinifySyntax:  true, // reads import.meta.env["KEY"] as import.meta.env.KEY
+		LegalComments: api.LegalCommentsNone,
+	})
+	// syntax errors are reported by the build itself
+	if len(result.Errors) > 0 {
+		return nil
+	}
+
+	messages := []api.Message{}
+	reported := map[string]bool{}
+	for _, reference := range envReferences(result.Code) {
+		key := reference.key
+		if (key != "" && env.IsPublic(key)) || reported[key] {
+			continue
+		}
+		reported[key] = true
+
+		if key == "" {
+			messages = append(messages, api.Message{
+				Text:     "import.meta.env[...] reads a variable by a computed name, read " + env.PublicPrefix + "* variables by their name so the build can check them",
+				Location: referenceLocation(filename, source, `import\.meta\.env\s*\[|\{[^{}]*\[[^{}]*\}\s*=\s*import\.meta\.env\b`),
+			})
+			continue
+		}
+
+		name := regexp.QuoteMeta(key)
+		messages = append(messages, api.Message{
+			Text:     "import.meta.env." + key + " is not public, only " + env.PublicPrefix + "* variables reach client code",
+			Location: referenceLocation(filename, source, `import\.meta\.env(\.`+name+`\b|\[\s*["'\x60]`+name+`["'\x60]\s*\])|\{[^{}]*\b`+name+`\b[^{}]*\}\s*=\s*import\.meta\.env\b`),
+		})
+	}
+	return messages
+}
+
+// referenceLocation finds where source reads a variable, the first text
+// matching pattern.
+func referenceLocation(filename string, source []byte, pattern string) *api.Location {
+	location := &api.Location{File: filepath.ToSlash(module.Rel(filename))}
+
+	match := regexp.MustCompile(pattern).FindIndex(source)
+	if match == nil {
+		return location
+	}
+
+	lineStart := bytes.LastIndexByte(source[:match[0]], '\n') + 1
+	lineEnd := len(source)
+	if i := bytes.IndexByte(source[match[0]:], '\n'); i != -1 {
+		lineEnd = match[0] + i
+	}
+
+	location.Line = bytes.Count(source[:match[0]], []byte("\n")) + 1
+	location.Column = match[0] - lineStart
+	location.Length = match[1] - match[0]
+	location.LineText = string(source[lineStart:lineEnd])
+	return location
+}
+
+// sourceLoader picks the esbuild loader of a script by its extension.
+func sourceLoader(filename string) api.Loader {
+	switch filepath.Ext(filename) {
+	case ".ts", ".mts", ".cts":
+		return api.LoaderTS
+
+	case ".tsx":
+		return api.LoaderTSX
+
+	case ".jsx":
+		return api.LoaderJSX
+
+	default:
+		return api.LoaderJS
+	}
+}
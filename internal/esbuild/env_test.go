@@ -0,0 +1,148 @@
+package esbuild
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sgq995/nova/internal/module"
+)
+
+func TestPrivateReferences(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		source   string
+		keys     []string // variables reported, in order, import.meta.env[...] for computed names
+		line     int      // of the first one
+	}{
+		{
+			name:   "public",
+			source: "console.log(import.meta.env.NOVA_PUBLIC_URL)",
+			keys:   []string{},
+		},
+		{
+			name:   "private",
+			source: "const a = 1\nconsole.log(import.meta.env.SECRET)",
+			keys:   []string{"SECRET"},
+			line:   2,
+		},
+		{
+			name:   "bracket access",
+			source: `console.log(import.meta.env["SECRET"])`,
+			keys:   []string{"SECRET"},
+			line:   1,
+		},
+		{
+			name:   "template interpolation",
+			source: "console.log(`${import.meta.env.SECRET}`)",
+			keys:   []string{"SECRET"},
+			line:   1,
+		},
+		{
+			name:   "reported once",
+			source: "f(import.meta.env.SECRET)\nf(import.meta.env.SECRET, import.meta.env.TOKEN)",
+			keys:   []string{"SECRET", "TOKEN"},
+			line:   1,
+		},
+		{
+			name:   "line comment",
+			source: "// reads import.meta.env.SECRET\nconsole.log(1)",
+			keys:   []string{},
+		},
+		{
+			name:   "block comment",
+			source: "/* import.meta.env.SECRET */ console.log(1)",
+			keys:   []string{},
+		},
+		{
+			name:   "string",
+			source: `console.log("import.meta.env.SECRET", 'import.meta.env.TOKEN')`,
+			keys:   []string{},
+		},
+		{
+			name:   "template text",
+			source: "console.log(`import.meta.env.SECRET`)",
+			keys:   []string{},
+		},
+		{
+			name:     "typescript",
+			filename: "main.ts",
+			source:   "const secret: string = import.meta.env.SECRET",
+			keys:     []string{"SECRET"},
+			line:     1,
+		},
+		{
+			name:     "jsx",
+			filename: "main.jsx",
+			source:   "export const App = () => <p title=\"import.meta.env.TOKEN\">{import.meta.env.SECRET}</p>",
+			keys:     []string{"SECRET"},
+			line:     1,
+		},
+		{
+			name:   "destructuring",
+			source: "const a = 1\nconst { NOVA_PUBLIC_URL, SECRET, TOKEN: token } = import.meta.env",
+			keys:   []string{"SECRET", "TOKEN"},
+			line:   2,
+		},
+		{
+			name:   "destructuring quoted",
+			source: `const { ["SECRET"]: secret, ...rest } = import.meta.env`,
+			keys:   []string{"SECRET"},
+			line:   1,
+		},
+		{
+			name:   "destructuring parameter",
+			source: "function f({ SECRET = 1 } = import.meta.env) {}",
+			keys:   []string{"SECRET"},
+			line:   1,
+		},
+		{
+			name:   "computed name",
+			source: "const key = 'SECRET'\nconsole.log(import.meta.env[key])",
+			keys:   []string{"import.meta.env[...]"},
+			line:   2,
+		},
+		{
+			name:   "computed destructuring",
+			source: "const { [key]: value } = import.meta.env",
+			keys:   []string{"import.meta.env[...]"},
+			line:   1,
+		},
+		{
+			name:   "whole object",
+			source: "console.log(import.meta.env, { ...import.meta.env })",
+			keys:   []string{},
+		},
+		{
+			name:   "syntax error",
+			source: "console.log(import.meta.env.SECRET",
+			keys:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tt.filename
+			if filename == "" {
+				filename = "main.js"
+			}
+
+			// sources are loaded by absolute path
+			messages := privateReferences(module.Abs(filename), []byte(tt.source))
+
+			keys := []string{}
+			for _, msg := range messages {
+				key, _, _ := strings.Cut(strings.TrimPrefix(msg.Text, "import.meta.env."), " ")
+				keys = append(keys, key)
+			}
+			if !slices.Equal(keys, tt.keys) {
+				t.Fatalf("reported %q, want %q", keys, tt.keys)
+			}
+
+			if len(messages) > 0 && messages[0].Location.Line != tt.line {
+				t.Errorf("reported at line %d, want %d", messages[0].Location.Line, tt.line)
+			}
+		})
+	}
+}
@@ -3,8 +3,11 @@ package esbuild
 import (
 	"errors"
 	"fmt"
+	"path/filepath"
 
 	"github.com/evanw/esbuild/pkg/api"
+	"github.com/sgq995/nova/internal/diagnostic"
+	"github.com/sgq995/nova/internal/module"
 )
 
 func esbuildError(messages []api.Message) error {
@@ -15,3 +18,22 @@ func esbuildError(messages []api.Message) error {
 	}
 	return errors.Join(errs...)
 }
+
+func esbuildDiagnostics(messages []api.Message) []diagnostic.Diagnostic {
+	diagnostics := []diagnostic.Diagnostic{}
+	for _, msg := range messages {
+		d := diagnostic.Diagnostic{
+			Source:  diagnostic.SourceESBuild,
+			Message: msg.Text,
+		}
+		if loc := msg.Location; loc != nil {
+			d.File = loc.File
+			d.Line = loc.Line
+			// esbuild columns are 0-based
+			d.Column = loc.Column + 1
+			d.Frame = diagnostic.Frame(module.Abs(filepath.FromSlash(loc.File)), d.Line, d.Column)
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
@@ -0,0 +1,242 @@
+package esbuild
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type metafileImport struct {
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	External bool   `json:"external"`
+}
+
+type metafile struct {
+	Inputs map[string]struct {
+		Imports []metafileImport `json:"imports"`
+	} `json:"inputs"`
+	Outputs map[string]struct {
+		Inputs    map[string]json.RawMessage `json:"inputs"`
+		CSSBundle string                     `json:"cssBundle"`
+	} `json:"outputs"`
+}
+
+// ModuleGraph links the JavaScript outputs of a dev build through the
+// imports of the source modules in them, paths are relative to the output
+// directory. Modules under router.src are built on their own, see
+// moduleURL, so each of their outputs is a single source module.
+type ModuleGraph struct {
+	importers map[string][]string
+	// urls lists the modules each output imports by URL
+	urls map[string][]string
+	// styles maps an output to the stylesheet of the CSS it imports
+	styles map[string]string
+}
+
+func newModuleGraph() *ModuleGraph {
+	return &ModuleGraph{
+		importers: map[string][]string{},
+		urls:      map[string][]string{},
+		styles:    map[string]string{},
+	}
+}
+
+func parseModuleGraph(outDir string, data string) (*ModuleGraph, error) {
+	graph := newModuleGraph()
+	if data == "" {
+		return graph, nil
+	}
+
+	var meta metafile
+	err := json.Unmarshal([]byte(data), &meta)
+	if err != nil {
+		return nil, err
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	rel := func(path string) string {
+		rel, err := filepath.Rel(outDir, filepath.Join(wd, path))
+		if err != nil {
+			return path
+		}
+		return filepath.ToSlash(rel)
+	}
+
+	// the outputs holding each source module
+	outputs := map[string][]string{}
+	for out, output := range meta.Outputs {
+		out := rel(out)
+		if path.Ext(out) != ".js" {
+			continue
+		}
+
+		for input := range output.Inputs {
+			outputs[input] = append(outputs[input], out)
+		}
+		if output.CSSBundle != "" {
+			graph.styles[out] = "/" + rel(output.CSSBundle)
+		}
+	}
+	built := map[string]bool{}
+	for _, outs := range outputs {
+		for _, out := range outs {
+			built[out] = true
+		}
+	}
+
+	link := func(importer, dep string) {
+		if importer != dep && !slices.Contains(graph.importers[dep], importer) {
+			graph.importers[dep] = append(graph.importers[dep], importer)
+		}
+	}
+
+	for input, source := range meta.Inputs {
+		for _, out := range outputs[input] {
+			for _, imp := range source.Imports {
+				if !imp.External {
+					for _, dep := range outputs[imp.Path] {
+						link(out, dep)
+					}
+					continue
+				}
+
+				// other external imports are node_modules and nova's own
+				dep := strings.TrimPrefix(imp.Path, "/")
+				if !built[dep] {
+					continue
+				}
+				link(out, dep)
+				if !slices.Contains(graph.urls[out], imp.Path) {
+					graph.urls[out] = append(graph.urls[out], imp.Path)
+				}
+			}
+		}
+	}
+
+	return graph, nil
+}
+
+// Chain returns the outputs that import output, directly or not, nearest
+// first.
+func (g *ModuleGraph) Chain(output string) []string {
+	chain := []string{}
+	visited := map[string]bool{output: true}
+	queue := []string{output}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		importers := slices.Clone(g.importers[current])
+		slices.Sort(importers)
+		for _, importer := range importers {
+			if visited[importer] {
+				continue
+			}
+			visited[importer] = true
+			chain = append(chain, importer)
+			queue = append(queue, importer)
+		}
+	}
+
+	return chain
+}
+
+// Importers maps output and every output importing it, directly or not, to
+// their direct importers. Hot updates walk it from output until each path
+// reaches a module accepting them.
+func (g *ModuleGraph) Importers(output string) map[string][]string {
+	importers := map[string][]string{}
+	for _, current := range append([]string{output}, g.Chain(output)...) {
+		direct := slices.Clone(g.importers[current])
+		slices.Sort(direct)
+		importers[current] = append([]string{}, direct...)
+	}
+	return importers
+}
+
+// moduleVersions tags the URL imports between dev modules with the build
+// that last changed the imported module or one it imports. Browsers keep a
+// module per URL, with the tags a module evaluated again by a hot update
+// imports fresh copies of the changed modules and shares the rest.
+type moduleVersions struct {
+	mu      sync.Mutex
+	build   int
+	sums    map[string][sha256.Size]byte
+	version map[string]int
+}
+
+func newModuleVersions() *moduleVersions {
+	return &moduleVersions{
+		sums:    map[string][sha256.Size]byte{},
+		version: map[string]int{},
+	}
+}
+
+// update rewrites the JavaScript files of a build in place and returns the
+// ones whose own code changed since the previous build, sorted. The others
+// may change too, only to import fresh copies of those.
+func (v *moduleVersions) update(files map[string][]byte, graph *ModuleGraph) []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.build++
+	changed := []string{}
+	sums := map[string][sha256.Size]byte{}
+	for filename, contents := range files {
+		if path.Ext(filename) != ".js" {
+			continue
+		}
+
+		if style, ok := graph.styles[filename]; ok {
+			contents = linkStyle(contents, style)
+			files[filename] = contents
+		}
+
+		sum := sha256.Sum256(contents)
+		sums[filename] = sum
+		if prev, exists := v.sums[filename]; exists && prev != sum {
+			changed = append(changed, filename)
+		}
+	}
+	v.sums = sums
+
+	for _, filename := range changed {
+		v.version[filename] = v.build
+		for _, importer := range graph.Chain(filename) {
+			v.version[importer] = v.build
+		}
+	}
+
+	for filename, urls := range graph.urls {
+		data, exists := files[filename]
+		if !exists {
+			continue
+		}
+
+		contents := string(data)
+		for _, url := range urls {
+			version := v.version[strings.TrimPrefix(url, "/")]
+			if version == 0 {
+				continue
+			}
+			tagged := url + "?v=" + strconv.Itoa(version)
+			contents = strings.ReplaceAll(contents, strconv.Quote(url), strconv.Quote(tagged))
+		}
+		files[filename] = []byte(contents)
+	}
+
+	slices.Sort(changed)
+	return changed
+}
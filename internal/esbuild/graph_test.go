@@ -0,0 +1,117 @@
+package esbuild
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestModuleVersions(t *testing.T) {
+	// index.js and other.js import lib/util.js, index.js imports a.js too
+	graph := newModuleGraph()
+	graph.importers = map[string][]string{
+		"lib/util.js": {"index.js", "other.js"},
+		"a.js":        {"index.js"},
+	}
+	graph.urls = map[string][]string{
+		"index.js": {"/lib/util.js", "/a.js"},
+		"other.js": {"/lib/util.js"},
+	}
+
+	sources := map[string]string{
+		"index.js":    `import "/lib/util.js"; import "/a.js";`,
+		"other.js":    `import "/lib/util.js";`,
+		"lib/util.js": `export const n = 1;`,
+		"a.js":        `export const a = 1;`,
+	}
+
+	tests := []struct {
+		name    string
+		edit    map[string]string
+		changed []string
+		imports map[string]string // output to what it contains after the build
+	}{
+		{
+			name:    "first build",
+			changed: []string{},
+			imports: map[string]string{"index.js": `import "/lib/util.js"; import "/a.js";`},
+		},
+		{
+			name:    "unchanged",
+			changed: []string{},
+			imports: map[string]string{"index.js": `import "/lib/util.js"; import "/a.js";`},
+		},
+		{
+			name:    "imported module",
+			edit:    map[string]string{"lib/util.js": `export const n = 2;`},
+			changed: []string{"lib/util.js"},
+			imports: map[string]string{
+				"index.js": `import "/lib/util.js?v=3"; import "/a.js";`,
+				"other.js": `import "/lib/util.js?v=3";`,
+			},
+		},
+		{
+			name:    "tags are kept",
+			changed: []string{},
+			imports: map[string]string{"index.js": `import "/lib/util.js?v=3"; import "/a.js";`},
+		},
+		{
+			name:    "importer",
+			edit:    map[string]string{"index.js": `import "/lib/util.js"; import "/a.js"; f();`},
+			changed: []string{"index.js"},
+			imports: map[string]string{"index.js": `import "/lib/util.js?v=3"; import "/a.js"; f();`},
+		},
+	}
+
+	v := newModuleVersions()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maps.Copy(sources, tt.edit)
+			files := map[string][]byte{}
+			for filename, contents := range sources {
+				files[filename] = []byte(contents)
+			}
+
+			changed := v.update(files, graph)
+			if !slices.Equal(changed, tt.changed) {
+				t.Errorf("changed %q, want %q", changed, tt.changed)
+			}
+			for filename, want := range tt.imports {
+				if got := string(files[filename]); got != want {
+					t.Errorf("%s = %s, want %s", filename, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestModuleGraphImporters(t *testing.T) {
+	graph := newModuleGraph()
+	graph.importers = map[string][]string{
+		"lib/util.js": {"other.js", "index.js"},
+		"other.js":    {"index.js"},
+	}
+
+	got := graph.Importers("lib/util.js")
+	want := map[string][]string{
+		"lib/util.js": {"index.js", "other.js"},
+		"index.js":    {},
+		"other.js":    {"index.js"},
+	}
+	if !maps.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("Importers = %v, want %v", got, want)
+	}
+}
+
+func TestLinkStyle(t *testing.T) {
+	contents := []byte(hmrBanner + "\nconsole.log(1)")
+	got := string(linkStyle(contents, "/lib/util.css"))
+
+	if !strings.Contains(got, `__nova_createHotContext(import.meta.url, "/lib/util.css");`) {
+		t.Errorf("stylesheet not handed to the hot context:\n%s", got)
+	}
+	if strings.Count(got, "\n") != strings.Count(string(contents), "\n") {
+		t.Errorf("lines moved:\n%s", got)
+	}
+}
@@ -0,0 +1,29 @@
+package esbuild
+
+import (
+	"fmt"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/sgq995/nova/internal/config"
+)
+
+// applyJSX configures the JSX transform of options from esbuild.jsx, dev
+// builds use the development runtime for better warnings.
+func applyJSX(options *api.BuildOptions, c *config.JSXConfig, dev bool) error {
+	switch c.Runtime {
+	case config.JSXAutomatic, "":
+		options.JSX = api.JSXAutomatic
+		options.JSXImportSource = c.ImportSource
+		options.JSXDev = dev
+
+	case config.JSXClassic:
+		options.JSX = api.JSXTransform
+		options.JSXFactory = c.Factory
+		options.JSXFragment = c.Fragment
+
+	default:
+		return fmt.Errorf("nova: unknown esbuild.jsx.runtime %q", c.Runtime)
+	}
+
+	return nil
+}
@@ -0,0 +1,18 @@
+package esbuild
+
+import (
+	"slices"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/sgq995/nova/plugin"
+)
+
+// applyPlugins puts the esbuild plugins of nova plugins first, so they load
+// the files they claim before nova resolves anything.
+func applyPlugins(options *api.BuildOptions, dev bool) {
+	plugins := []api.Plugin{}
+	for _, p := range plugin.Hooks[plugin.ESBuild]() {
+		plugins = append(plugins, p.ESBuildPlugins(dev)...)
+	}
+	options.Plugins = slices.Concat(plugins, options.Plugins)
+}
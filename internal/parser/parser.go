@@ -39,7 +39,26 @@ func ParseImportsGo(filename string) ([]string, error) {
 	return imports, nil
 }
 
-func ParseImportsHTML(filename string) ([]string, error) {
+// resolveRef returns the local file a src or href attribute points to,
+// absolute references are resolved against root.
+func resolveRef(basepath, root, ref string) (string, bool) {
+	ref, _, _ = strings.Cut(ref, "#")
+	ref, _, _ = strings.Cut(ref, "?")
+	if ref == "" || strings.HasPrefix(ref, "//") || strings.Contains(ref, ":") {
+		// external or data URL
+		return "", false
+	}
+
+	if strings.HasPrefix(ref, "/") {
+		return filepath.Join(root, filepath.FromSlash(ref)), true
+	}
+	return filepath.Join(basepath, filepath.FromSlash(ref)), true
+}
+
+// ParseImportsHTML lists the local files referenced by the scripts, links
+// and images of an HTML file or template. References starting with a slash
+// are served from root, router.src.
+func ParseImportsHTML(filename string, root string) ([]string, error) {
 	basepath := filepath.Dir(filename)
 
 	b, err := os.ReadFile(filename)
@@ -59,26 +78,26 @@ func ParseImportsHTML(filename string) ([]string, error) {
 			continue
 		}
 
+		var key string
 		switch n.DataAtom {
 		case atom.Script, atom.Img:
-			for _, attr := range n.Attr {
-				if strings.ToLower(attr.Key) == "src" {
-					src := filepath.Join(basepath, attr.Val)
-					src = filepath.Clean(src)
-					imports = append(imports, src)
-					break
-				}
-			}
+			key = "src"
 
 		case atom.Link:
-			for _, attr := range n.Attr {
-				if strings.ToLower(attr.Key) == "href" {
-					href := filepath.Join(basepath, attr.Val)
-					href = filepath.Clean(href)
-					imports = append(imports, href)
-					break
-				}
+			key = "href"
+
+		default:
+			continue
+		}
+
+		for _, attr := range n.Attr {
+			if strings.ToLower(attr.Key) != key {
+				continue
+			}
+			if ref, ok := resolveRef(basepath, root, attr.Val); ok {
+				imports = append(imports, ref)
 			}
+			break
 		}
 	}
 
@@ -0,0 +1,201 @@
+package project
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"maps"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/sgq995/nova/internal/module"
+)
+
+// goPackage is the part of the `go list -json` output the import graph
+// needs.
+type goPackage struct {
+	ImportPath string
+	Dir        string
+	Deps       []string
+	DepOnly    bool
+	Module     *struct {
+		Main bool
+	}
+}
+
+// listPackages runs `go list -deps` on dirs, broken packages are listed too
+// so a syntax error doesn't hide the rest of the graph.
+func listPackages(dirs []string) ([]goPackage, error) {
+	args := []string{"list", "-e", "-deps", "-json=ImportPath,Dir,Deps,DepOnly,Module"}
+	for _, dir := range dirs {
+		args = append(args, "./"+filepath.ToSlash(module.Rel(dir)))
+	}
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("go", args...)
+	cmd.Dir = module.Root()
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("nova: go list: %w\n%s", err, stderr)
+	}
+
+	packages := []goPackage{}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		pkg := goPackage{}
+		err := dec.Decode(&pkg)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, pkg)
+	}
+
+	return packages, nil
+}
+
+// importGraph knows the directories of the local packages each route file
+// depends on, its own included.
+type importGraph struct {
+	mu   sync.Mutex
+	deps map[string][]string
+
+	// shapes holds the package clause and imports of every Go file seen, a
+	// file saved with the same ones leaves the graph as it is
+	shapes map[string][]string
+}
+
+func newImportGraph() *importGraph {
+	return &importGraph{
+		deps:   map[string][]string{},
+		shapes: map[string][]string{},
+	}
+}
+
+// fileShape returns the package name of filename followed by its imports,
+// or nil when it can't be read.
+func fileShape(filename string) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), filename, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+
+	shape := []string{f.Name.Name}
+	for _, spec := range f.Imports {
+		shape = append(shape, spec.Path.Value)
+	}
+	slices.Sort(shape[1:])
+	return shape
+}
+
+// stale records the shape of files and reports whether the graph must be
+// listed again: a file was created, removed or changed its package clause or
+// imports, go.mod or go.sum changed, or the route files are others.
+func (g *importGraph) stale(files []string, routeFiles []string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	stale := !slices.Equal(slices.Sorted(maps.Keys(g.deps)), slices.Sorted(slices.Values(routeFiles)))
+	for _, filename := range files {
+		switch filepath.Base(filename) {
+		case "go.mod", "go.sum":
+			stale = true
+			continue
+		}
+
+		shape := fileShape(filename)
+		prev, known := g.shapes[filename]
+		if !known || !slices.Equal(prev, shape) {
+			stale = true
+		}
+
+		if shape == nil {
+			delete(g.shapes, filename)
+		} else {
+			g.shapes[filename] = shape
+		}
+	}
+	return stale
+}
+
+func (g *importGraph) update(routeFiles []string) error {
+	dirs := map[string]struct{}{}
+	for _, filename := range routeFiles {
+		dirs[filepath.Dir(filename)] = struct{}{}
+	}
+
+	deps := map[string][]string{}
+	if len(dirs) > 0 {
+		packages, err := listPackages(slices.Sorted(maps.Keys(dirs)))
+		if err != nil {
+			// the next change lists again, whatever its shape
+			g.mu.Lock()
+			clear(g.shapes)
+			g.mu.Unlock()
+			return err
+		}
+
+		local := map[string]string{}
+		for _, pkg := range packages {
+			if pkg.Module != nil && pkg.Module.Main {
+				local[pkg.ImportPath] = pkg.Dir
+			}
+		}
+
+		roots := map[string][]string{}
+		for _, pkg := range packages {
+			if pkg.DepOnly {
+				continue
+			}
+
+			pkgDirs := []string{pkg.Dir}
+			for _, importPath := range pkg.Deps {
+				if dir, exists := local[importPath]; exists {
+					pkgDirs = append(pkgDirs, dir)
+				}
+			}
+			roots[pkg.Dir] = pkgDirs
+		}
+
+		for _, filename := range routeFiles {
+			deps[filename] = roots[filepath.Dir(filename)]
+		}
+	}
+
+	g.mu.Lock()
+	g.deps = deps
+	g.mu.Unlock()
+
+	return nil
+}
+
+// affected returns the route files that depend on the package of filename,
+// every route depends on go.mod and go.sum.
+func (g *importGraph) affected(filename string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch filepath.Base(filename) {
+	case "go.mod", "go.sum":
+		return slices.Collect(maps.Keys(g.deps))
+	}
+
+	dir := filepath.Dir(filename)
+	routeFiles := []string{}
+	for routeFile, dirs := range g.deps {
+		if slices.Contains(dirs, dir) {
+			routeFiles = append(routeFiles, routeFile)
+		}
+	}
+	return routeFiles
+}
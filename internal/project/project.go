@@ -2,12 +2,17 @@ package project
 
 import (
 	"context"
+	"crypto/sha256"
 	"maps"
 	"os"
+	"path/filepath"
 	"slices"
+	"time"
 
 	"github.com/sgq995/nova/internal/codegen"
 	"github.com/sgq995/nova/internal/config"
+	"github.com/sgq995/nova/internal/diagnostic"
+	"github.com/sgq995/nova/internal/env"
 	"github.com/sgq995/nova/internal/esbuild"
 	"github.com/sgq995/nova/internal/logger"
 	"github.com/sgq995/nova/internal/module"
@@ -26,69 +31,128 @@ type ProjectContext interface {
 	Build() error
 }
 
+const shutdownTimeout = 5 * time.Second
+
 type projectImpl struct {
+	config *config.Config
+
 	scanner *scanner
 	router  *router.Router
 	codegen *codegen.Codegen
 	esbuild *esbuild.ESBuildContext
 	server  *server.Server
+	imports *importGraph
+
+	// bundles holds the hash of every esbuild output served from memory
+	bundles map[string][sha256.Size]byte
 }
 
-func (p *projectImpl) esbuildOnEnd(files map[string][]byte) error {
-	messages := []*server.Message{}
+func (p *projectImpl) esbuildOnEnd(result *esbuild.DevResult) error {
+	for _, d := range result.Diagnostics {
+		logger.Errorf("[esbuild] %s", d)
+	}
 
-	for filename, contents := range files {
-		messages = append(messages, server.UpdateFileMessage(filename, contents))
+	p.server.Built(diagnostic.SourceESBuild, result.Diagnostics)
+	messages := []*server.Message{
+		server.DiagnosticMessage(diagnostic.SourceESBuild, result.Diagnostics),
 	}
 
+	// a failed build has no outputs, keep serving the last good ones
+	if len(result.Diagnostics) > 0 {
+		p.server.Send(server.BulkMessage(messages...))
+		return nil
+	}
+
+	bundles := map[string][sha256.Size]byte{}
+	for filename, contents := range result.Files {
+		sum := sha256.Sum256(contents)
+		bundles[filename] = sum
+
+		prev, exists := p.bundles[filename]
+		switch {
+		case !exists:
+			messages = append(messages, server.CreateFileMessage(filename, contents))
+
+		case prev == sum:
+			continue
+
+		case slices.Contains(result.Changed, filename):
+			importers := result.Graph.Importers(filename)
+			messages = append(messages, server.UpdateModuleMessage(filename, contents, importers))
+
+		default:
+			messages = append(messages, server.UpdateFileMessage(filename, contents))
+		}
+	}
+
+	for filename := range p.bundles {
+		if _, exists := bundles[filename]; !exists {
+			messages = append(messages, server.DeleteFileMessage(filename))
+		}
+	}
+	p.bundles = bundles
+
 	p.server.Send(server.BulkMessage(messages...))
 
 	return nil
 }
 
+// isPage reports whether filename is a page under router.src.
+func (p *projectImpl) isPage(filename string) bool {
+	rel, err := filepath.Rel(module.Abs(p.config.Router.Src), filename)
+	return err == nil && filepath.Ext(filename) == ".html" && filepath.IsLocal(rel)
+}
+
+// isRouteFile reports whether filename may declare routes, only Go files
+// under router.http do.
+func (p *projectImpl) isRouteFile(filename string) bool {
+	rel, err := filepath.Rel(module.Abs(p.config.Router.Http), filename)
+	return err == nil && filepath.Ext(filename) == ".go" && filepath.IsLocal(rel)
+}
+
+// goWatcherCallback handles every Go file of the module plus go.mod and
+// go.sum. Route files are parsed and generated again, the routes importing
+// a changed package are only refreshed.
 func (p *projectImpl) goWatcherCallback(event watcher.Event, files []string) error {
+	logger.Infof("%s %s", event, files)
+
+	routeFiles := []string{}
+	for _, filename := range files {
+		if p.isRouteFile(filename) {
+			routeFiles = append(routeFiles, filename)
+		}
+	}
+
+	messages := []*server.Message{}
+	generated := false
+
 	switch event {
 	case watcher.CreateEvent, watcher.UpdateEvent:
-		logger.Infof("%s %s", event, files)
-
-		routesMap, err := p.router.ParseRoutes(files)
+		routesMap, err := p.router.ParseRoutes(routeFiles)
 		if err != nil {
 			return err
 		}
 
-		for _, filename := range files {
-			err = p.codegen.GenerateRouteModule(filename, routesMap[filename])
-			if err != nil {
-				return err
-			}
+		modules, err := p.generateRoutes(routeFiles, routesMap)
+		if err != nil {
+			return err
 		}
+		generated = true
 
-		routes := slices.Concat(slices.Collect(maps.Values(routesMap))...)
-		messages := []*server.Message{}
-
-		for _, route := range routes {
-			switch route := route.(type) {
-			case *router.RenderRouteGo:
-				messages = append(messages, server.CreateRouteMessage(route.Pattern))
-
-			case *router.RestRouteGo:
-				messages = append(messages, server.CreateRouteMessage(route.Pattern))
-			}
-		}
+		for filename, routes := range routesMap {
+			for _, route := range routes {
+				switch route := route.(type) {
+				case *router.RenderRouteGo:
+					messages = append(messages, server.CreateRouteMessage(route.Pattern, modules[filename]))
 
-		if event == watcher.UpdateEvent {
-			for _, filename := range files {
-				messages = append(messages, server.UpdateFileMessage(filename, []byte{}))
+				case *router.RestRouteGo:
+					messages = append(messages, server.CreateRouteMessage(route.Pattern, modules[filename]))
+				}
 			}
 		}
 
-		p.server.Send(server.BulkMessage(messages...))
-
 	case watcher.DeleteEvent:
-		logger.Infof("%s %s", event, files)
-
-		messages := []*server.Message{}
-		for _, filename := range files {
+		for _, filename := range routeFiles {
 			routes := p.router.Routes[filename]
 			for _, route := range routes {
 				switch route := route.(type) {
@@ -99,39 +163,180 @@ func (p *projectImpl) goWatcherCallback(event watcher.Event, files []string) err
 					messages = append(messages, server.DeleteRouteMessage(route.Pattern))
 				}
 			}
+			p.router.Remove(filename)
 		}
 
-		p.server.Send(server.BulkMessage(messages...))
+		if len(routeFiles) > 0 {
+			_, err := p.generateRoutes(nil, nil)
+			if err != nil {
+				return err
+			}
+			generated = true
+		}
 	}
 
+	dependents := p.dependentRoutes(files, routeFiles)
+	messages = append(messages, dependents...)
+
+	// the dev application is rebuilt once, whether its main.go or a package
+	// it imports changed
+	if p.config.Server.Runtime == config.RuntimeApp && (generated || len(dependents) > 0) {
+		p.server.ReloadApp()
+	}
+
+	p.server.Send(server.BulkMessage(messages...))
+
 	return nil
 }
 
+// dependentRoutes refreshes the import graph and returns the messages for
+// the routes importing one of files, but those in regenerated which were
+// handled already. Routes are built on every request in the "module"
+// runtime, so only browsers need to know. The graph is only listed again
+// when files changed its shape.
+func (p *projectImpl) dependentRoutes(files []string, regenerated []string) []*server.Message {
+	affected := map[string]struct{}{}
+	collect := func() {
+		for _, filename := range files {
+			for _, routeFile := range p.imports.affected(filename) {
+				affected[routeFile] = struct{}{}
+			}
+		}
+	}
+
+	// the graph before the change knows removed imports, the one after the
+	// added ones
+	collect()
+	routeFiles := slices.Collect(maps.Keys(p.router.Routes))
+	if p.imports.stale(files, routeFiles) {
+		err := p.imports.update(routeFiles)
+		if err != nil {
+			logger.Errorf("%+v", err)
+		}
+		collect()
+	}
+
+	for _, filename := range regenerated {
+		delete(affected, filename)
+	}
+
+	messages := []*server.Message{}
+	for routeFile := range affected {
+		logger.Infof("refresh %s", module.Rel(routeFile))
+
+		for _, route := range p.router.Routes[routeFile] {
+			switch route := route.(type) {
+			case *router.RenderRouteGo:
+				messages = append(messages, server.UpdateRouteMessage(route.Pattern))
+
+			case *router.RestRouteGo:
+				messages = append(messages, server.UpdateRouteMessage(route.Pattern))
+			}
+		}
+	}
+
+	return messages
+}
+
+// generateRoutes writes the dev entry points for the given route files and
+// returns the generated main.go of each one. The "app" runtime regenerates a
+// single main.go with every known route instead, so no module is returned,
+// and the caller reloads the application. The result is recorded as a
+// codegen build.
+func (p *projectImpl) generateRoutes(files []string, routesMap map[string][]router.Route) (modules map[string]string, err error) {
+	defer func() {
+		diagnostics := []diagnostic.Diagnostic{}
+		if err != nil {
+			diagnostics = append(diagnostics, diagnostic.Diagnostic{Source: diagnostic.SourceCodegen, Message: err.Error()})
+		}
+		p.server.Built(diagnostic.SourceCodegen, diagnostics)
+	}()
+
+	modules = map[string]string{}
+
+	if p.config.Server.Runtime == config.RuntimeApp {
+		err = p.codegen.GenerateDevServer(p.router.Routes)
+		if err != nil {
+			return nil, err
+		}
+		return modules, nil
+	}
+
+	for _, filename := range files {
+		target, err := p.codegen.GenerateRouteModule(filename, routesMap[filename])
+		if err != nil {
+			return nil, err
+		}
+		modules[filename] = target
+	}
+
+	return modules, nil
+}
+
+// htmlWatcherCallback refreshes the pages rendered with the changed
+// templates, route processes re-parse templates by themselves so only the
+// browsers showing those routes need to reload. Static pages have no route,
+// browsers reload whatever they show.
 func (p *projectImpl) htmlWatcherCallback(event watcher.Event, files []string) error {
 	logger.Infof("%s %s", event, files)
 
 	messages := []*server.Message{}
 
 	for _, filename := range files {
-		switch event {
-		case watcher.CreateEvent, watcher.UpdateEvent:
-			// TODO: create route
-			messages = append(messages, server.UpdateFileMessage(filename, []byte{}))
+		routes := p.router.TemplateRoutes(filename)
+		if len(routes) == 0 {
+			if p.isPage(filename) {
+				messages = append(messages, server.ReloadMessage(module.Rel(filename)+" changed"))
+				continue
+			}
+			logger.Debugf("%s is not used by any route", module.Rel(filename))
+			continue
+		}
 
-		case watcher.DeleteEvent:
-			messages = append(messages, server.DeleteFileMessage(filename))
+		for _, route := range routes {
+			messages = append(messages, server.UpdateRouteMessage(route.Pattern))
 		}
 	}
 
 	p.server.Send(server.BulkMessage(messages...))
 
-	return nil
+	// pages and templates define the entry points
+	return p.updateEntryPoints()
 }
 
+// staticWatcherCallback looks for new entry points when scripts and
+// stylesheets are created or deleted, updates are rebuilt by esbuild itself.
+func (p *projectImpl) staticWatcherCallback(event watcher.Event, files []string) error {
+	if event == watcher.UpdateEvent {
+		return nil
+	}
+
+	return p.updateEntryPoints()
+}
+
+// updateEntryPoints scans the project again and recreates the esbuild
+// context if pages and templates reference other entry points now. Outputs
+// of removed entries are dropped by esbuildOnEnd once the new context builds.
+func (p *projectImpl) updateEntryPoints() error {
+	if err := p.scanner.scan(); err != nil {
+		return err
+	}
+
+	return p.esbuild.SetEntryPoints(p.scanner.entryPoints)
+}
+
+// Dispose stops the esbuild context and shuts the dev server down, waiting
+// up to shutdownTimeout for in-flight requests.
 func (p *projectImpl) Dispose() {
 	p.esbuild.Dispose()
-	// s.runner.stop()
-	p.server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := p.server.Shutdown(ctx)
+	if err != nil {
+		logger.Warnf("shutdown: %+v", err)
+	}
 }
 
 type projectContextImpl struct {
@@ -142,17 +347,44 @@ func Context(c config.Config) (ProjectContext, error) {
 	return &projectContextImpl{config: &c}, nil
 }
 
+// loadEnv sets NOVA_ENV to mode and the variables of its env files, the
+// process environment wins over them. Dev processes inherit them all.
+func loadEnv(mode string) error {
+	os.Setenv("NOVA_ENV", mode)
+
+	vars, err := env.Load(mode)
+	if err != nil {
+		return err
+	}
+	applied := env.Apply(vars)
+	logger.Debugf("[env] %d variables set from %v", len(applied), env.Files(mode))
+
+	return nil
+}
+
 func (p *projectContextImpl) Serve(ctx context.Context) (Project, error) {
-	os.Setenv("NOVA_ENV", "development")
+	if err := loadEnv("development"); err != nil {
+		return nil, err
+	}
 
 	scanner := newScanner(p.config)
 	e := esbuild.NewESBuildContext(p.config)
 	r := router.New(p.config)
 	c := codegen.NewCodegen(p.config)
-	s := server.New(p.config)
+	s, err := server.New(p.config)
+	if err != nil {
+		return nil, err
+	}
 
 	logger.Infof("starting nova dev server...")
-	scanner.scan()
+	if err := s.Listen(); err != nil {
+		return nil, err
+	}
+
+	if err := scanner.scan(); err != nil {
+		s.Close()
+		return nil, err
+	}
 
 	// onBuild := func(files map[string][]byte) error {
 	// 	messages := []*server.Message{}
@@ -172,23 +404,27 @@ func (p *projectContextImpl) Serve(ctx context.Context) (Project, error) {
 	// }
 
 	project := &projectImpl{
+		config:  p.config,
 		scanner: scanner,
 		router:  r,
 		codegen: c,
 		esbuild: e,
 		server:  s,
+		imports: newImportGraph(),
 	}
 
 	project.router.Scan()
 
-	static := slices.Concat(scanner.jsFiles, scanner.cssFiles)
-	if err := e.Start(static, project.esbuildOnEnd); err != nil {
+	if err := e.Start(scanner.entryPoints, project.esbuildOnEnd); err != nil {
+		s.Close()
 		return nil, err
 	}
 
-	go watcher.WatchDir(ctx, p.config.Router.Http, watcher.CallbackMap{
-		"*.go": project.goWatcherCallback,
-	})
+	go watcher.Supervise(ctx, p.config, ".", watcher.CallbackMap{
+		"*.go,go.mod,go.sum": project.goWatcherCallback,
+		"*.html":             project.htmlWatcherCallback,
+		staticPatterns():     project.staticWatcherCallback,
+	}, project.server.WatcherStatus)
 
 	// TODO: new approach:
 	//       - internal/pages for golang backend files, the user can create subdirectories
@@ -332,13 +568,23 @@ func (p *projectContextImpl) Serve(ctx context.Context) (Project, error) {
 	// 	},
 	// })
 
-	go project.server.ListenAndServe()
+	logger.Infof("nova dev server running at %s", project.server.URL())
+
+	go func() {
+		err := project.server.Serve()
+		if err != nil {
+			logger.Errorf("%+v", err)
+		}
+	}()
 
 	return project, nil
 }
 
 func (p *projectContextImpl) Build() error {
-	os.Setenv("NOVA_ENV", "production")
+	err := loadEnv("production")
+	if err != nil {
+		return err
+	}
 
 	e := esbuild.NewESBuild(p.config)
 	s := newScanner(p.config)
@@ -349,11 +595,11 @@ func (p *projectContextImpl) Build() error {
 		return err
 	}
 
-	static := slices.Concat(s.jsFiles, s.cssFiles)
 	staticDir := module.Join(p.config.Codegen.OutDir, "static")
 	staticEntryMap, err := e.Build(esbuild.BuildOptions{
-		EntryPoints: static,
+		EntryPoints: s.entryPoints,
 		Outdir:      staticDir,
+		Outbase:     module.Abs(p.config.Router.Src),
 		Hashing:     true,
 	})
 	if err != nil {
@@ -391,7 +637,11 @@ func (p *projectContextImpl) Build() error {
 	if err != nil {
 		return err
 	}
-	err = c.GenerateProductionServer(routes)
+	// secrets come from the environment of the deployment, the binary only
+	// knows the mode and what client code may see anyway
+	defaults := env.Public()
+	defaults["NOVA_ENV"] = "production"
+	err = c.GenerateProductionServer(routes, defaults)
 	if err != nil {
 		return err
 	}
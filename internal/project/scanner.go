@@ -1,14 +1,19 @@
 package project
 
 import (
+	"errors"
 	"io/fs"
 	"maps"
+	"os"
 	"path/filepath"
 	"slices"
+	"strings"
 
 	"github.com/sgq995/nova/internal/config"
+	"github.com/sgq995/nova/internal/logger"
 	"github.com/sgq995/nova/internal/module"
 	"github.com/sgq995/nova/internal/parser"
+	"github.com/sgq995/nova/plugin"
 )
 
 type scanner struct {
@@ -22,6 +27,10 @@ type scanner struct {
 	assetFiles    []string
 
 	pages []string
+
+	// entryPoints are the scripts and stylesheets referenced by pages and
+	// templates, sorted, the other files are modules imported by them
+	entryPoints []string
 }
 
 func newScanner(c *config.Config) *scanner {
@@ -44,6 +53,11 @@ func (p *scanner) scan() error {
 		return err
 	}
 
+	err = p.findEntryPoints()
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -69,7 +83,7 @@ func (p *scanner) findFiles(base string) error {
 		case ".go":
 			p.goFiles = append(p.goFiles, path)
 
-		case ".js", ".ts":
+		case ".js", ".ts", ".jsx", ".tsx", ".mjs", ".mts":
 			p.jsFiles = append(p.jsFiles, path)
 
 		case ".css":
@@ -79,6 +93,10 @@ func (p *scanner) findFiles(base string) error {
 			p.htmlFiles = append(p.htmlFiles, path)
 
 		default:
+			if claimed(path) {
+				p.jsFiles = append(p.jsFiles, path)
+				break
+			}
 			p.assetFiles = append(p.assetFiles, path)
 		}
 
@@ -111,7 +129,7 @@ func (p *scanner) linkFiles() error {
 	}
 
 	for _, filename := range p.htmlFiles {
-		imports, err := parser.ParseImportsHTML(filename)
+		imports, err := parser.ParseImportsHTML(filename, module.Abs(p.config.Router.Src))
 		if err != nil {
 			return err
 		}
@@ -140,3 +158,106 @@ func (p *scanner) findPageFiles() error {
 
 	return nil
 }
+
+func isEntryPoint(filename string) bool {
+	switch filepath.Ext(filename) {
+	case ".js", ".ts", ".jsx", ".tsx", ".mjs", ".mts", ".css":
+		return true
+
+	default:
+		return claimed(filename)
+	}
+}
+
+// claimed reports whether a plugin bundles filename, see plugin.Scanner.
+func claimed(filename string) bool {
+	ext := filepath.Ext(filename)
+	for _, s := range plugin.Hooks[plugin.Scanner]() {
+		if slices.Contains(s.Extensions(), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// staticPatterns are the watcher patterns of the files bundled by esbuild.
+func staticPatterns() string {
+	patterns := []string{"*.js", "*.ts", "*.jsx", "*.tsx", "*.mjs", "*.mts", "*.css"}
+	for _, s := range plugin.Hooks[plugin.Scanner]() {
+		for _, ext := range s.Extensions() {
+			patterns = append(patterns, "*"+ext)
+		}
+	}
+	return strings.Join(patterns, ",")
+}
+
+// routeTemplates lists the templates of the route files under router.http,
+// they may reference scripts too.
+func (p *scanner) routeTemplates() ([]string, error) {
+	templates := []string{}
+	err := filepath.WalkDir(module.Abs(p.config.Router.Http), func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || filepath.Ext(path) != ".go" {
+			return nil
+		}
+
+		imports, err := parser.ParseImportsGo(path)
+		if err != nil {
+			return err
+		}
+		for _, filename := range imports {
+			if filepath.Ext(filename) == ".html" {
+				templates = append(templates, filename)
+			}
+		}
+		return nil
+	})
+	return templates, err
+}
+
+func (p *scanner) findEntryPoints() error {
+	templates, err := p.routeTemplates()
+	if err != nil {
+		return err
+	}
+
+	src := module.Abs(p.config.Router.Src)
+	documents := slices.Concat(p.htmlFiles, p.templateFiles, templates)
+	entryPoints := map[string]struct{}{}
+
+	for _, document := range documents {
+		imports, err := parser.ParseImportsHTML(document, src)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, filename := range imports {
+			if !isEntryPoint(filename) {
+				continue
+			}
+			if _, err := os.Stat(filename); err != nil {
+				continue
+			}
+
+			rel, err := filepath.Rel(src, filename)
+			if err != nil || !filepath.IsLocal(rel) {
+				logger.Warnf("%s: %s is outside %s, it is not bundled", module.Rel(document), module.Rel(filename), p.config.Router.Src)
+				continue
+			}
+
+			entryPoints[filename] = struct{}{}
+		}
+	}
+
+	p.entryPoints = slices.Sorted(maps.Keys(entryPoints))
+	return nil
+}
@@ -2,6 +2,7 @@ package router
 
 import (
 	"maps"
+	"path/filepath"
 
 	"github.com/sgq995/nova/internal/config"
 )
@@ -36,3 +37,29 @@ func (r *Router) ParseRoutes(files []string) (map[string][]Route, error) {
 	maps.Copy(r.Routes, routesMap)
 	return routesMap, nil
 }
+
+func (r *Router) Remove(filename string) {
+	delete(r.Routes, filename)
+}
+
+// TemplateRoutes returns the render routes that use the template filename,
+// an absolute path.
+func (r *Router) TemplateRoutes(filename string) []*RenderRouteGo {
+	routes := []*RenderRouteGo{}
+	for source, fileRoutes := range r.Routes {
+		for _, route := range fileRoutes {
+			render, ok := route.(*RenderRouteGo)
+			if !ok {
+				continue
+			}
+
+			for _, tmpl := range render.Templates {
+				if filepath.Join(filepath.Dir(source), tmpl) == filename {
+					routes = append(routes, render)
+					break
+				}
+			}
+		}
+	}
+	return routes
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package server
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// isAddrInUse reports whether listening failed because the port is taken,
+// winsock has an errno of its own for it.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, windows.WSAEADDRINUSE) || errors.Is(err, syscall.EADDRINUSE)
+}
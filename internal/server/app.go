@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/sgq995/nova/internal/diagnostic"
+	"github.com/sgq995/nova/internal/logger"
+)
+
+const appReadyTimeout = 30 * time.Second
+
+type appInstance struct {
+	addr  string
+	bin   string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	proxy *httputil.ReverseProxy
+	done  chan struct{}
+}
+
+func (inst *appInstance) stop() {
+	inst.stdin.Close()
+	select {
+	case <-inst.done:
+	case <-time.After(time.Second):
+		inst.cmd.Process.Kill()
+		<-inst.done
+	}
+	os.Remove(inst.bin)
+}
+
+// devApp builds the generated dev main.go into a single binary and proxies
+// requests to it, swapping the upstream only once a new build is ready.
+type devApp struct {
+	main   string
+	report reportFunc
+
+	generation int
+	reloads    chan struct{}
+	ctx        context.Context
+	cancel     context.CancelFunc
+
+	mu      sync.Mutex
+	current *appInstance
+}
+
+func newDevApp(main string, report reportFunc) *devApp {
+	ctx, cancel := context.WithCancel(context.Background())
+	app := &devApp{
+		main:    main,
+		report:  report,
+		reloads: make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	go app.run()
+	return app
+}
+
+func (app *devApp) run() {
+	for {
+		select {
+		case <-app.ctx.Done():
+			return
+
+		case <-app.reloads:
+			err := app.rebuild()
+			if err != nil {
+				logger.Errorf("[app] %+v", err)
+			}
+		}
+	}
+}
+
+// reload schedules a rebuild, requests made while a build is running are
+// coalesced into a single follow-up build.
+func (app *devApp) reload() {
+	select {
+	case app.reloads <- struct{}{}:
+	default:
+	}
+}
+
+func (app *devApp) rebuild() error {
+	app.generation++
+	bin := filepath.Join(filepath.Dir(app.main), fmt.Sprintf("app-%d", app.generation))
+	if runtime.GOOS == "windows" {
+		bin += ".exe"
+	}
+
+	logger.Infof("[app] go build -o %s %s", bin, app.main)
+	var stderr bytes.Buffer
+	build := exec.CommandContext(app.ctx, "go", "build", "-o", bin, app.main)
+	build.Stdout = os.Stdout
+	build.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	err := build.Run()
+	if err != nil {
+		if app.ctx.Err() == nil {
+			app.report(diagnostic.SourceGo, diagnostic.ParseGo(build.Dir, stderr.Bytes()))
+		}
+		return err
+	}
+
+	inst, err := app.start(bin)
+	if err != nil {
+		os.Remove(bin)
+		app.report(diagnostic.SourceGo, []diagnostic.Diagnostic{
+			{Source: diagnostic.SourceGo, Message: err.Error()},
+		})
+		return err
+	}
+	app.report(diagnostic.SourceGo, nil)
+
+	app.mu.Lock()
+	if app.ctx.Err() != nil {
+		app.mu.Unlock()
+		inst.stop()
+		return app.ctx.Err()
+	}
+	prev := app.current
+	app.current = inst
+	app.mu.Unlock()
+
+	if prev != nil {
+		prev.stop()
+	}
+
+	logger.Infof("[app] ready (%s)", inst.addr)
+	return nil
+}
+
+func (app *devApp) start(bin string) (*appInstance, error) {
+	addr, err := freeAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(), "NOVA_DEV_ADDR="+addr)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+
+	inst := &appInstance{
+		addr:  addr,
+		bin:   bin,
+		cmd:   cmd,
+		stdin: stdin,
+		done:  done,
+	}
+
+	upstream := &url.URL{Scheme: "http", Host: addr}
+	err = waitReady(app.ctx, upstream, done)
+	if err != nil {
+		inst.stop()
+		return nil, err
+	}
+
+	inst.proxy = httputil.NewSingleHostReverseProxy(upstream)
+	inst.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		logger.Errorf("[app] %+v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+
+	return inst, nil
+}
+
+func waitReady(ctx context.Context, upstream *url.URL, done <-chan struct{}) error {
+	health := upstream.JoinPath("/@nova/health").String()
+	client := http.Client{Timeout: time.Second}
+
+	ctx, cancel := context.WithTimeout(ctx, appReadyTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-done:
+			return errors.New("nova: application exited before it was ready")
+
+		case <-ticker.C:
+			res, err := client.Get(health)
+			if err != nil {
+				continue
+			}
+			res.Body.Close()
+			if res.StatusCode < http.StatusBadRequest {
+				return nil
+			}
+		}
+	}
+}
+
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+func (app *devApp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	app.mu.Lock()
+	inst := app.current
+	app.mu.Unlock()
+
+	if inst == nil {
+		http.Error(w, "nova: application is not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	inst.proxy.ServeHTTP(w, r)
+}
+
+func (app *devApp) close() {
+	app.cancel()
+
+	app.mu.Lock()
+	inst := app.current
+	app.current = nil
+	app.mu.Unlock()
+
+	if inst != nil {
+		inst.stop()
+	}
+}
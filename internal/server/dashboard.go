@@ -0,0 +1,251 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sgq995/nova/internal/diagnostic"
+	"github.com/sgq995/nova/internal/watcher"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+const (
+	requestLogSize = 200
+
+	// dashboardThrottle bounds how often dashboards are told to refresh
+	dashboardThrottle = 250 * time.Millisecond
+)
+
+type requestEntry struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	URI      string    `json:"uri"`
+	Pattern  string    `json:"pattern"`
+	Status   int       `json:"status"`
+	Size     int64     `json:"size"`
+	Duration float64   `json:"duration"` // milliseconds
+}
+
+// sourceStatus is the last result reported by a source, a build or a part
+// of the dev server at runtime.
+type sourceStatus struct {
+	Source      string                  `json:"source"`
+	Time        time.Time               `json:"time"`
+	Diagnostics []diagnostic.Diagnostic `json:"diagnostics"`
+}
+
+type dashboardState struct {
+	Started  time.Time        `json:"started"`
+	Routes   []routeInfo      `json:"routes"`
+	Files    []fileInfo       `json:"files"`
+	Requests []requestEntry   `json:"requests"`
+	Builds   []sourceStatus   `json:"builds"`
+	Health   []sourceStatus   `json:"health"`
+	Clients  []clientInfo     `json:"clients"`
+	Watchers []watcher.Status `json:"watchers"`
+}
+
+// dashboard backs the /@nova/ page, it keeps what the rest of the dev server
+// doesn't: recent requests, the result of the last builds and the health of
+// route processes and proxies.
+type dashboard struct {
+	hmr     *hotModuleReplacer
+	started time.Time
+
+	mu       sync.Mutex
+	requests []requestEntry // newest last
+	builds   map[string]sourceStatus
+	health   map[string]sourceStatus
+	watchers map[string]watcher.Status
+	pending  bool
+}
+
+func newDashboard(hmr *hotModuleReplacer) *dashboard {
+	return &dashboard{
+		hmr:      hmr,
+		started:  time.Now(),
+		builds:   map[string]sourceStatus{},
+		health:   map[string]sourceStatus{},
+		watchers: map[string]watcher.Status{},
+	}
+}
+
+// changed tells dashboard clients to refresh, bursts of changes are
+// coalesced into a single event.
+func (d *dashboard) changed() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.pending {
+		return
+	}
+	d.pending = true
+
+	time.AfterFunc(dashboardThrottle, func() {
+		d.mu.Lock()
+		d.pending = false
+		d.mu.Unlock()
+
+		d.hmr.ps.notifyDashboard(dashboardEvent())
+	})
+}
+
+// build records the result of an esbuild, codegen or go build, reported as
+// the diagnostics of its source.
+func (d *dashboard) build(source string, diagnostics []diagnostic.Diagnostic) {
+	d.mu.Lock()
+	d.builds[source] = sourceStatus{
+		Source:      source,
+		Time:        time.Now(),
+		Diagnostics: diagnostics,
+	}
+	d.mu.Unlock()
+
+	d.changed()
+}
+
+// reportHealth records what route processes and proxies report while
+// serving, it is kept apart so requests don't pass for builds.
+func (d *dashboard) reportHealth(source string, diagnostics []diagnostic.Diagnostic) {
+	d.mu.Lock()
+	d.health[source] = sourceStatus{
+		Source:      source,
+		Time:        time.Now(),
+		Diagnostics: diagnostics,
+	}
+	d.mu.Unlock()
+
+	d.changed()
+}
+
+// forget drops the health of a source that stopped serving.
+func (d *dashboard) forget(source string) {
+	d.mu.Lock()
+	delete(d.health, source)
+	d.mu.Unlock()
+}
+
+func (d *dashboard) watcher(status watcher.Status) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.watchers[status.Dir] = status
+}
+
+func (d *dashboard) record(entry requestEntry) {
+	d.mu.Lock()
+	if len(d.requests) == requestLogSize {
+		d.requests = append(d.requests[:0], d.requests[1:]...)
+	}
+	d.requests = append(d.requests, entry)
+	d.mu.Unlock()
+
+	d.changed()
+}
+
+func (d *dashboard) state() *dashboardState {
+	d.mu.Lock()
+	requests := slices.Clone(d.requests)
+	builds := sortedStatus(d.builds)
+	health := sortedStatus(d.health)
+	watchers := make([]watcher.Status, 0, len(d.watchers))
+	for _, dir := range slices.Sorted(maps.Keys(d.watchers)) {
+		watchers = append(watchers, d.watchers[dir])
+	}
+	d.mu.Unlock()
+
+	slices.Reverse(requests)
+
+	return &dashboardState{
+		Started:  d.started,
+		Routes:   d.hmr.router.list(),
+		Files:    d.hmr.fsys.list(),
+		Requests: requests,
+		Builds:   builds,
+		Health:   health,
+		Clients:  d.hmr.ps.clients(),
+		Watchers: watchers,
+	}
+}
+
+func sortedStatus(statuses map[string]sourceStatus) []sourceStatus {
+	sorted := make([]sourceStatus, 0, len(statuses))
+	for _, source := range slices.Sorted(maps.Keys(statuses)) {
+		sorted = append(sorted, statuses[source])
+	}
+	return sorted
+}
+
+func (d *dashboard) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(dashboardHTML)
+}
+
+func (d *dashboard) serveState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(d.state())
+}
+
+// statusRecorder captures the status and size of a response, Unwrap keeps
+// flushing and hijacking available through http.ResponseController.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (rec *statusRecorder) WriteHeader(statusCode int) {
+	if rec.status == 0 {
+		rec.status = statusCode
+	}
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += int64(n)
+	return n, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// logRequests records every request but those of nova itself.
+func (d *dashboard) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/@nova/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			d.record(requestEntry{
+				Time:     start,
+				Method:   r.Method,
+				URI:      r.RequestURI,
+				Pattern:  r.Pattern,
+				Status:   rec.status,
+				Size:     rec.size,
+				Duration: float64(time.Since(start).Microseconds()) / 1000,
+			})
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sgq995/nova/internal/diagnostic"
+	"github.com/sgq995/nova/internal/module"
+)
+
+// handlerPanic is the payload of the error frame a route module sends when
+// its handler panics.
+type handlerPanic struct {
+	Message string `json:"message"`
+	Stack   string `json:"stack"`
+}
+
+type stackFrame struct {
+	Function string
+	File     string
+	Line     int
+	// User is set for frames inside the module, only those get a snippet
+	User    bool
+	Snippet string
+}
+
+var stackLocationRe = regexp.MustCompile(`^\t(.+\.go):(\d+)(?: \+0x[0-9a-f]+)?$`)
+
+// parseStack reads a goroutine trace as printed by runtime/debug.Stack,
+// skipping the frames of the recovery itself. Frames of generated, the main.go
+// of the route module, don't count as user code.
+func parseStack(stack string, generated string) []stackFrame {
+	frames := []stackFrame{}
+	root := module.Root() + string(filepath.Separator)
+
+	function := ""
+	scanner := bufio.NewScanner(strings.NewReader(stack))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		m := stackLocationRe.FindStringSubmatch(line)
+		if m == nil {
+			if !strings.HasPrefix(line, "goroutine ") && line != "" {
+				function = line
+			}
+			continue
+		}
+
+		if strings.HasPrefix(function, "panic(") {
+			// everything up to here belongs to the recover handler
+			frames = frames[:0]
+			function = ""
+			continue
+		}
+
+		file := m[1]
+		lineno, _ := strconv.Atoi(m[2])
+		frame := stackFrame{
+			Function: function,
+			File:     file,
+			Line:     lineno,
+		}
+		if strings.HasPrefix(file, root) && file != generated {
+			frame.User = true
+			frame.File = module.Rel(file)
+			frame.Snippet = diagnostic.Frame(file, lineno, 0)
+		}
+		frames = append(frames, frame)
+		function = ""
+	}
+
+	return frames
+}
+
+// parseCrash extracts an unrecovered panic from the stderr of a process, as
+// printed by the runtime before exiting.
+func parseCrash(stderr []byte) *handlerPanic {
+	output := string(stderr)
+
+	start := strings.Index(output, "panic: ")
+	if start < 0 {
+		return nil
+	}
+	output = output[start+len("panic: "):]
+
+	message, stack, found := strings.Cut(output, "\n\ngoroutine ")
+	if !found {
+		return nil
+	}
+
+	return &handlerPanic{
+		Message: strings.TrimSpace(message),
+		Stack:   "goroutine " + stack,
+	}
+}
+
+// panicDiagnostics reports a panic at its innermost frame inside the module.
+// Template errors keep their own location.
+func panicDiagnostics(p *handlerPanic, frames []stackFrame) []diagnostic.Diagnostic {
+	diagnostics := diagnostic.ParseGo("", []byte(p.Message))
+	if len(diagnostics) > 0 && diagnostics[0].Source == diagnostic.SourceTemplate {
+		return diagnostics
+	}
+
+	d := diagnostic.Diagnostic{
+		Source:  diagnostic.SourceRuntime,
+		Message: "panic: " + p.Message,
+	}
+	for _, frame := range frames {
+		if frame.User {
+			d.File = frame.File
+			d.Line = frame.Line
+			d.Frame = frame.Snippet
+			break
+		}
+	}
+
+	return []diagnostic.Diagnostic{d}
+}
+
+const debugPage string = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>nova: {{.Title}}</title>
+<script type="module" src="/@nova/hmr.js"></script>
+<style>
+body { margin: 0; padding: 2rem; font-family: ui-monospace, monospace; background: #181818; color: #e8e8e8; }
+h1 { color: #ff5555; font-size: 1.25rem; }
+h2 { color: #aaaaaa; font-size: 1rem; margin-top: 2rem; }
+pre { background: #222; padding: 1rem; overflow-x: auto; }
+table { border-collapse: collapse; }
+td { padding: 0 1rem 0 0; vertical-align: top; }
+.frame { margin: 0.5rem 0; }
+.dim { color: #888888; }
+</style>
+</head>
+<body>
+<h1>nova: {{.Title}}</h1>
+{{range .Diagnostics}}
+<p>{{.}}</p>
+{{with .Frame}}<pre>{{.}}</pre>{{end}}
+{{end}}
+{{with .Frames}}
+<h2>Stack</h2>
+{{range .}}
+<div class="frame{{if not .User}} dim{{end}}">
+<div>{{.Function}}</div>
+<div>{{.File}}:{{.Line}}</div>
+{{with .Snippet}}<pre>{{.}}</pre>{{end}}
+</div>
+{{end}}
+{{end}}
+{{with .Request}}
+<h2>Request</h2>
+<p>{{.Method}} {{.RequestURI}} {{.Proto}}</p>
+<table>
+{{range $key, $values := .Header}}{{range $values}}<tr><td>{{$key}}</td><td>{{.}}</td></tr>{{end}}{{end}}
+</table>
+{{end}}
+{{with .Logs}}
+<h2>Logs</h2>
+<pre>{{.}}</pre>
+{{end}}
+{{with .Output}}
+<h2>Output</h2>
+<pre>{{.}}</pre>
+{{end}}
+</body>
+</html>
+`
+
+var debugPageTmpl *template.Template = template.Must(template.New("debug").Parse(debugPage))
+
+type debugInfo struct {
+	Status      int
+	Title       string
+	Diagnostics []diagnostic.Diagnostic
+	Panic       *handlerPanic
+	Frames      []stackFrame
+	Request     *http.Request
+	Logs        string
+	Output      string
+}
+
+// writeDebugPage responds with a page that describes why a request failed,
+// it loads the hmr client so the page recovers on its own once the error is
+// fixed.
+func writeDebugPage(w http.ResponseWriter, info *debugInfo) {
+	if info.Title == "" {
+		info.Title = "build failed"
+		if info.Panic != nil && info.Request != nil {
+			info.Title = fmt.Sprintf("%s %s panicked", info.Request.Method, info.Request.URL.Path)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if info.Status == 0 {
+		info.Status = http.StatusInternalServerError
+	}
+	w.WriteHeader(info.Status)
+	debugPageTmpl.Execute(w, info)
+}
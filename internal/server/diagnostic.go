@@ -0,0 +1,7 @@
+package server
+
+import (
+	"github.com/sgq995/nova/internal/diagnostic"
+)
+
+type reportFunc func(source string, diagnostics []diagnostic.Diagnostic)
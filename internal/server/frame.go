@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Route modules talk to the dev server through frames on stdin and stdout:
+// a one byte type, a big endian uint32 payload length and the payload. The
+// generated main.go holds the other end of this protocol, both must be kept
+// in sync.
+type frameType byte
+
+const (
+	// frameHead carries the request head (server to module) or the response
+	// head (module to server) as JSON.
+	frameHead frameType = iota + 1
+
+	// frameData carries a chunk of the request or response body.
+	frameData
+
+	// frameTrailer carries trailers as a JSON encoded http.Header.
+	frameTrailer
+
+	// frameFlush asks the server to flush the response.
+	frameFlush
+
+	// frameEnd marks the end of a body.
+	frameEnd
+
+	// frameCancel tells the module the request context is done.
+	frameCancel
+
+	// frameHijack tells the server the handler took over the connection,
+	// from then on raw bytes travel as frameConnData.
+	frameHijack
+
+	// frameConnData carries raw bytes of a hijacked connection.
+	frameConnData
+
+	// frameConnClose closes a hijacked connection.
+	frameConnClose
+
+	// frameLog carries output the handler printed to stdout or through the
+	// log package.
+	frameLog
+
+	// frameError carries a recovered panic as JSON, it ends the response.
+	frameError
+)
+
+const (
+	frameHeaderSize = 5
+	frameMaxPayload = 1 << 24
+	frameChunkSize  = 32 << 10
+)
+
+var errFrameTooLarge = errors.New("nova: frame too large")
+
+type frame struct {
+	typ     frameType
+	payload []byte
+}
+
+func readFrame(r io.Reader) (frame, error) {
+	var head [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return frame{}, err
+	}
+
+	length := binary.BigEndian.Uint32(head[1:])
+	if length > frameMaxPayload {
+		return frame{}, errFrameTooLarge
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return frame{}, err
+	}
+
+	return frame{typ: frameType(head[0]), payload: payload}, nil
+}
+
+// frameWriter serialises frames written from several goroutines.
+type frameWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newFrameWriter(w io.Writer) *frameWriter {
+	return &frameWriter{w: w}
+}
+
+func (fw *frameWriter) write(typ frameType, payload []byte) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	var head [frameHeaderSize]byte
+	head[0] = byte(typ)
+	binary.BigEndian.PutUint32(head[1:], uint32(len(payload)))
+
+	if _, err := fw.w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := fw.w.Write(payload)
+	return err
+}
+
+func (fw *frameWriter) writeJSON(typ frameType, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return fw.write(typ, b)
+}
+
+// copyFrames sends everything read from r as frames of type typ.
+func (fw *frameWriter) copyFrames(typ frameType, r io.Reader) error {
+	buf := make([]byte, frameChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if werr := fw.write(typ, buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
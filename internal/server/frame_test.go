@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []frame
+	}{
+		{
+			name:   "empty payload",
+			frames: []frame{{typ: frameEnd, payload: []byte{}}},
+		},
+		{
+			name: "request",
+			frames: []frame{
+				{typ: frameHead, payload: []byte(`{"method":"POST"}`)},
+				{typ: frameData, payload: []byte("hello")},
+				{typ: frameTrailer, payload: []byte(`{"X-Sum":["1"]}`)},
+				{typ: frameEnd, payload: []byte{}},
+			},
+		},
+		{
+			name:   "large payload",
+			frames: []frame{{typ: frameConnData, payload: bytes.Repeat([]byte{0xFF}, 1<<20)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			fw := newFrameWriter(buf)
+			for _, f := range tt.frames {
+				if err := fw.write(f.typ, f.payload); err != nil {
+					t.Fatalf("write: %v", err)
+				}
+			}
+
+			for i, want := range tt.frames {
+				got, err := readFrame(buf)
+				if err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+				if got.typ != want.typ || !bytes.Equal(got.payload, want.payload) {
+					t.Errorf("frame %d = %d %q, want %d %q", i, got.typ, got.payload, want.typ, want.payload)
+				}
+			}
+
+			if _, err := readFrame(buf); !errors.Is(err, io.EOF) {
+				t.Errorf("read past the last frame: %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		err   error
+	}{
+		{
+			name:  "truncated header",
+			input: []byte{byte(frameData), 0, 0},
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "truncated payload",
+			input: []byte{byte(frameData), 0, 0, 0, 5, 'h', 'e'},
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "too large",
+			input: []byte{byte(frameData), 0x01, 0, 0, 1},
+			err:   errFrameTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readFrame(bytes.NewReader(tt.input))
+			if !errors.Is(err, tt.err) {
+				t.Errorf("readFrame error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCopyFrames(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		chunks []int
+	}{
+		{name: "empty", size: 0, chunks: []int{}},
+		{name: "one chunk", size: 10, chunks: []int{10}},
+		{name: "exact chunk", size: frameChunkSize, chunks: []int{frameChunkSize}},
+		{name: "several chunks", size: 2*frameChunkSize + 1, chunks: []int{frameChunkSize, frameChunkSize, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Repeat("x", tt.size)
+
+			buf := &bytes.Buffer{}
+			err := newFrameWriter(buf).copyFrames(frameData, strings.NewReader(body))
+			if err != nil {
+				t.Fatalf("copyFrames: %v", err)
+			}
+
+			chunks := []int{}
+			received := []byte{}
+			for buf.Len() > 0 {
+				f, err := readFrame(buf)
+				if err != nil {
+					t.Fatalf("readFrame: %v", err)
+				}
+				if f.typ != frameData {
+					t.Errorf("frame type = %d, want %d", f.typ, frameData)
+				}
+				chunks = append(chunks, len(f.payload))
+				received = append(received, f.payload...)
+			}
+
+			if string(received) != body {
+				t.Errorf("received %d bytes, want %d", len(received), len(body))
+			}
+			if !slices.Equal(chunks, tt.chunks) {
+				t.Errorf("chunks = %v, want %v", chunks, tt.chunks)
+			}
+		})
+	}
+}
@@ -1,29 +1,63 @@
 package server
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
 	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/sgq995/nova/internal/logger"
+	"github.com/sgq995/nova/plugin"
 )
 
+// memEntry is a file of memFS, contents are never modified in place so they
+// can be shared with open files.
+type memEntry struct {
+	contents []byte
+	modTime  time.Time
+	etag     string
+}
+
+// memFS holds the build outputs served in dev. Directories are implicit,
+// they exist as long as a file lives under them.
 type memFS struct {
-	mu    sync.Mutex
-	files map[string][]byte
+	mu    sync.RWMutex
+	files map[string]*memEntry
 }
 
 func newMemFS() *memFS {
 	return &memFS{
-		files: map[string][]byte{},
+		files: map[string]*memEntry{},
 	}
 }
 
 func (fsys *memFS) update(filename string, contents []byte) {
+	sum := sha256.Sum256(contents)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
 	fsys.mu.Lock()
 	defer fsys.mu.Unlock()
 	logger.Debugf("[server] update %s\n", filename)
-	fsys.files[filename] = contents
+
+	// rebuilds often produce the same output, keep the cache valid for it
+	if prev, exists := fsys.files[filename]; exists && prev.etag == etag {
+		return
+	}
+
+	fsys.files[filename] = &memEntry{
+		contents: contents,
+		modTime:  time.Now(),
+		etag:     etag,
+	}
 }
 
 func (fsys *memFS) remove(filename string) {
@@ -34,42 +68,283 @@ func (fsys *memFS) remove(filename string) {
 }
 
 func (fsys *memFS) Open(name string) (fs.File, error) {
-	if f, exists := fsys.files[name]; exists {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	if entry, exists := fsys.files[name]; exists {
 		return &memFile{
-			name:     name,
-			size:     int64(len(f)),
-			contents: f,
+			info:   newFileInfo(path.Base(name), entry),
+			Reader: bytes.NewReader(entry.contents),
 		}, nil
 	}
-	return nil, fs.ErrNotExist
+
+	info, entries, exists := fsys.dirLocked(name)
+	if !exists {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &memDir{
+		name:    name,
+		info:    info,
+		entries: entries,
+	}, nil
+}
+
+func (fsys *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	if _, exists := fsys.files[name]; exists {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+
+	_, entries, exists := fsys.dirLocked(name)
+	if !exists {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return entries, nil
 }
 
+// dirLocked lists the direct children of the directory name, sorted by
+// name. A directory is as recent as the newest file under it.
+func (fsys *memFS) dirLocked(name string) (*memFileInfo, []fs.DirEntry, bool) {
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+
+	dir := &memFileInfo{name: path.Base(name), mode: fs.ModeDir | 0755}
+	children := map[string]*memFileInfo{}
+
+	for filename, entry := range fsys.files {
+		rest, found := strings.CutPrefix(filename, prefix)
+		if !found {
+			continue
+		}
+
+		if entry.modTime.After(dir.modTime) {
+			dir.modTime = entry.modTime
+		}
+
+		child, _, isDir := strings.Cut(rest, "/")
+		if !isDir {
+			children[child] = newFileInfo(child, entry)
+			continue
+		}
+
+		info, exists := children[child]
+		if !exists {
+			info = &memFileInfo{name: child, mode: fs.ModeDir | 0755}
+			children[child] = info
+		}
+		if entry.modTime.After(info.modTime) {
+			info.modTime = entry.modTime
+		}
+	}
+
+	if len(children) == 0 && name != "." {
+		return nil, nil, false
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, info := range children {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	return dir, entries, true
+}
+
+// fileInfo describes a file of memFS for the dashboard.
+type fileInfo struct {
+	Path    string    `json:"path"`
+	Size    int       `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	ETag    string    `json:"etag"`
+}
+
+func (fsys *memFS) list() []fileInfo {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	files := make([]fileInfo, 0, len(fsys.files))
+	for filename, entry := range fsys.files {
+		files = append(files, fileInfo{
+			Path:    filename,
+			Size:    len(entry.contents),
+			ModTime: entry.modTime,
+			ETag:    entry.etag,
+		})
+	}
+	slices.SortFunc(files, func(a, b fileInfo) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return files
+}
+
+// etag returns the entity tag of the file served for name, directories are
+// served through their index.html.
+func (fsys *memFS) etag(name string) (string, bool) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	if entry, exists := fsys.files[name]; exists {
+		return entry.etag, true
+	}
+	if entry, exists := fsys.files[path.Join(name, "index.html")]; exists {
+		return entry.etag, true
+	}
+	return "", false
+}
+
+// contentTypes covers the extensions served in dev that the mime package
+// may not know, depending on the system tables.
+var contentTypes = map[string]string{
+	".js":   "text/javascript; charset=utf-8",
+	".mjs":  "text/javascript; charset=utf-8",
+	".css":  "text/css; charset=utf-8",
+	".map":  "application/json",
+	".json": "application/json",
+	".html": "text/html; charset=utf-8",
+	".svg":  "image/svg+xml",
+	".wasm": "application/wasm",
+}
+
+func contentType(name string) string {
+	ext := path.Ext(name)
+	if t, exists := contentTypes[ext]; exists {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// scriptSources are the extensions esbuild turns into a .js output, pages
+// may reference the source while in dev.
+var scriptSources = []string{".ts", ".jsx", ".tsx", ".mjs", ".mts"}
+
+// isScriptSource reports whether ext is turned into a .js output, plugins
+// claim extensions of their own.
+func isScriptSource(ext string) bool {
+	if slices.Contains(scriptSources, ext) {
+		return true
+	}
+	for _, s := range plugin.Hooks[plugin.Scanner]() {
+		if slices.Contains(s.Extensions(), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileServer serves memFS with an ETag per file, so browsers revalidate
+// instead of fetching outputs that didn't change.
+func (fsys *memFS) fileServer() http.Handler {
+	files := http.FileServerFS(fsys)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name == "" {
+			name = "."
+		}
+
+		if _, exists := fsys.etag(name); !exists && isScriptSource(path.Ext(name)) {
+			output := strings.TrimSuffix(name, path.Ext(name)) + ".js"
+			if _, exists := fsys.etag(output); exists {
+				name = output
+				r = r.Clone(r.Context())
+				r.URL.Path = "/" + output
+			}
+		}
+
+		if etag, exists := fsys.etag(name); exists {
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		if t := contentType(name); t != "" {
+			w.Header().Set("Content-Type", t)
+		}
+
+		files.ServeHTTP(w, r)
+	})
+}
+
+var errNotDir = errors.New("not a directory")
+
+var errIsDir = errors.New("is a directory")
+
 type memFile struct {
-	name     string
-	size     int64
-	contents []byte
+	info *memFileInfo
+	*bytes.Reader
 }
 
 func (f *memFile) Stat() (fs.FileInfo, error) {
-	return &memFileInfo{
-		name: f.name,
-		size: f.size,
-	}, nil
+	return f.info, nil
 }
 
-func (f *memFile) Read(out []byte) (int, error) {
-	n := copy(out, f.contents)
-	f.contents = f.contents[n:]
-	return n, nil
+func (f *memFile) Close() error {
+	return nil
 }
 
-func (f *memFile) Close() error {
+type memDir struct {
+	name    string
+	info    *memFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errIsDir}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
+
+func (d *memDir) Close() error {
 	return nil
 }
 
 type memFileInfo struct {
-	name string
-	size int64
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(name string, entry *memEntry) *memFileInfo {
+	return &memFileInfo{
+		name:    name,
+		size:    int64(len(entry.contents)),
+		mode:    0644,
+		modTime: entry.modTime,
+	}
 }
 
 func (fi *memFileInfo) Name() string {
@@ -81,15 +356,15 @@ func (fi *memFileInfo) Size() int64 {
 }
 
 func (fi *memFileInfo) Mode() fs.FileMode {
-	return 0755
+	return fi.mode
 }
 
 func (fi *memFileInfo) ModTime() time.Time {
-	return time.Now()
+	return fi.modTime
 }
 
 func (fi *memFileInfo) IsDir() bool {
-	return false
+	return fi.mode.IsDir()
 }
 
 func (fi *memFileInfo) Sys() any {
@@ -1,142 +1,134 @@
 package server
 
 import (
-	"fmt"
 	"net/http"
-	"strings"
 	"sync"
+
+	"github.com/sgq995/nova/internal/diagnostic"
 )
 
-type pubSub struct {
-	mu   sync.Mutex
-	subs map[chan string]*sync.WaitGroup
-}
+type hotModuleReplacer struct {
+	fsys    *memFS
+	router  *memRouter
+	handler http.Handler
 
-func newPubSub() *pubSub {
-	return &pubSub{
-		subs: make(map[chan string]*sync.WaitGroup),
-	}
-}
+	ps        *pubSub
+	dashboard *dashboard
 
-func (ps *pubSub) notify(filename string) {
-	ps.mu.Lock()
-	defer ps.mu.Unlock()
+	// done ends every HMR stream when the server shuts down
+	done      chan struct{}
+	closeOnce sync.Once
 
-	for sub, wg := range ps.subs {
-		wg.Add(1)
-		go func() {
-			sub <- filename
-			wg.Done()
-		}()
-	}
+	mu          sync.Mutex
+	mux         *http.ServeMux
+	diagnostics map[string][]diagnostic.Diagnostic
 }
 
-func (ps *pubSub) subscribe(sub chan string) {
-	ps.mu.Lock()
-	defer ps.mu.Unlock()
+func newHotModuleReplacer(router *memRouter, handler http.Handler) *hotModuleReplacer {
+	hmr := &hotModuleReplacer{
+		fsys:    newMemFS(),
+		router:  router,
+		handler: handler,
+		ps:      newPubSub(),
+		mux:     http.NewServeMux(),
+		done:    make(chan struct{}),
 
-	if wg, exists := ps.subs[sub]; exists {
-		wg.Wait()
+		diagnostics: map[string][]diagnostic.Diagnostic{},
 	}
-
-	ps.subs[sub] = &sync.WaitGroup{}
+	hmr.dashboard = newDashboard(hmr)
+	return hmr
 }
 
-func (ps *pubSub) unsubscribe(sub chan string) {
-	ps.mu.Lock()
-	defer ps.mu.Unlock()
-
-	if wg, exists := ps.subs[sub]; exists {
-		wg.Add(1)
-		go func() {
-			loop := true
-			for loop {
-				select {
-				case <-sub:
-					// we might need to delay a little bit here
-
-				default:
-					loop = false
-				}
-			}
-			wg.Done()
-		}()
-		wg.Wait()
-		delete(ps.subs, sub)
-		close(sub)
-	}
+func (hmr *hotModuleReplacer) generateServeMux() {
+	hmr.mu.Lock()
+	mux := hmr.router.newServeMux(hmr.handler)
+	mux.Handle("/", hmr.fsys.fileServer())
+	hmr.mux = mux
+	hmr.mu.Unlock()
 }
 
-type hotModuleReplacer struct {
-	fsys        *memFS
-	router      *memRouter
-	routeModule *routeModule
+// changes accumulates the events produced by a message, so a bulk message
+// reaches clients as a single event per kind.
+type changes struct {
+	diagnostics []*Event
+	files       *Event
+	routes      *Event
+	reloads     []*Event
+	remux       bool
+}
 
-	ps *pubSub
+func (hmr *hotModuleReplacer) apply(msg *Message, c *changes) {
+	payload := msg.Payload
 
-	mu  sync.Mutex
-	mux *http.ServeMux
-}
+	switch msg.Type {
+	case BulkType:
+		for _, m := range payload["messages"].([]*Message) {
+			hmr.apply(m, c)
+		}
 
-func newHotModuleReplacer(pagespath string) *hotModuleReplacer {
-	return &hotModuleReplacer{
-		fsys:        newMemFS(),
-		router:      newMemRouter(),
-		routeModule: newRouteModule(pagespath),
-		ps:          newPubSub(),
-		mux:         http.NewServeMux(),
-	}
-}
+	case CreateFileType:
+		filename := hmr.createFile(payload)
+		c.files.Created = append(c.files.Created, filename)
+
+	case UpdateFileType:
+		filename := hmr.updateFile(payload)
+		c.files.Updated = append(c.files.Updated, filename)
+
+	case DeleteFileType:
+		filename := hmr.deleteFile(payload)
+		c.files.Deleted = append(c.files.Deleted, filename)
+
+	case UpdateModuleType:
+		filename := hmr.updateFile(payload)
+		c.files.Updated = append(c.files.Updated, filename)
+		c.files.Modules = append(c.files.Modules, ModuleUpdate{
+			Path:      filename,
+			Importers: payload["importers"].(map[string][]string),
+		})
+
+	case CreateRouteType:
+		pattern, exists := hmr.createRoute(payload)
+		if exists {
+			// the pattern is registered already, only clients need to know
+			c.routes.Updated = append(c.routes.Updated, pattern)
+		} else {
+			c.routes.Created = append(c.routes.Created, pattern)
+			c.remux = true
+		}
 
-func (hmr *hotModuleReplacer) generateServeMux() {
-	hmr.mu.Lock()
-	mux := hmr.router.newServeMux(hmr.routeModule)
-	mux.Handle("/", http.FileServerFS(hmr.fsys))
-	hmr.mux = mux
-	hmr.mu.Unlock()
-}
+	case UpdateRouteType:
+		c.routes.Updated = append(c.routes.Updated, payload["pattern"].(string))
+
+	case DeleteRouteType:
+		pattern, module := hmr.deleteRoute(payload)
+		c.routes.Deleted = append(c.routes.Deleted, pattern)
+		c.remux = true
+
+		// nothing requests a removed route module to clear its diagnostics
+		if module != "" && !hmr.router.serves(module) {
+			for _, kind := range []string{diagnostic.SourceGo, diagnostic.SourceRuntime} {
+				source := routeSource(kind, module)
+				hmr.dashboard.forget(source)
+				if hmr.setDiagnostics(source, nil) {
+					c.diagnostics = append(c.diagnostics, diagnosticEvent(source, nil))
+				}
+			}
+		}
 
-func (hmr *hotModuleReplacer) bulk(payload map[string]any) (created []string, updated []string, deleted []string, routes []string) {
-	messages := payload["messages"].([]*Message)
-	for _, msg := range messages {
-		// msg := msg.(map[string]any)
-		// typ := int(msg["type"].(float64))
-		// payload := msg["payload"].(map[string]any)
-		typ := msg.Type
-		payload := msg.Payload
-
-		switch typ {
-		case CreateFileType: // CreateFileType.String()
-			filename := hmr.createFile(payload)
-			created = append(created, filename)
-
-		case UpdateFileType: // ` + UpdateFileType.String() + `
-			filename := hmr.updateFile(payload)
-			updated = append(updated, filename)
-
-		case DeleteFileType: // ` + DeleteFileType.String() + `
-			filename := hmr.deleteFile(payload)
-			deleted = append(deleted, filename)
-
-		case CreateRouteType: // ` + CreateRouteType.String() + `
-			pattern := hmr.createRoute(payload)
-			routes = append(routes, pattern)
-
-		// TODO: udate route, just need to notify ServeNovaHMR
-		// NOTE: DO NOT generate server mux, it's not needed because the pattern is
-		//       registered already
-
-		case DeleteRouteType: // ` + DeleteRouteType.String() + `
-			pattern := hmr.deleteRoute(payload)
-			routes = append(routes, pattern)
+	case DiagnosticType:
+		source := payload["source"].(string)
+		diagnostics := payload["diagnostics"].([]diagnostic.Diagnostic)
+		if hmr.setDiagnostics(source, diagnostics) {
+			c.diagnostics = append(c.diagnostics, diagnosticEvent(source, diagnostics))
 		}
+
+	case ReloadType:
+		c.reloads = append(c.reloads, reloadEvent(payload["reason"].(string)))
 	}
-	return
 }
 
 func (hmr *hotModuleReplacer) createFile(payload map[string]any) string {
 	filename := payload["filename"].(string)
-	// contents, _ := base64.StdEncoding.DecodeString(payload["contents"].(string))
 	contents := payload["contents"].([]byte)
 	hmr.fsys.update(filename, contents)
 	return filename
@@ -144,7 +136,6 @@ func (hmr *hotModuleReplacer) createFile(payload map[string]any) string {
 
 func (hmr *hotModuleReplacer) updateFile(payload map[string]any) string {
 	filename := payload["filename"].(string)
-	// contents, _ := base64.StdEncoding.DecodeString(payload["contents"].(string))
 	contents := payload["contents"].([]byte)
 	hmr.fsys.update(filename, contents)
 	return filename
@@ -156,104 +147,71 @@ func (hmr *hotModuleReplacer) deleteFile(payload map[string]any) string {
 	return filename
 }
 
-func (hmr *hotModuleReplacer) createRoute(payload map[string]any) string {
+func (hmr *hotModuleReplacer) createRoute(payload map[string]any) (string, bool) {
 	pattern := payload["pattern"].(string)
-	hmr.router.add(pattern)
-	return pattern
+	module := payload["module"].(string)
+	exists := hmr.router.add(pattern, module)
+	return pattern, exists
 }
 
-func (hmr *hotModuleReplacer) deleteRoute(payload map[string]any) string {
+func (hmr *hotModuleReplacer) deleteRoute(payload map[string]any) (string, string) {
 	pattern := payload["pattern"].(string)
-	hmr.router.remove(pattern)
-	return pattern
+	module := hmr.router.remove(pattern)
+	return pattern, module
 }
 
-func (hmr *hotModuleReplacer) Send(msg *Message) {
-	switch msg.Type {
-	case BulkType: // ` + BulkType.String() + `
-		created, updated, deleted, routes := hmr.bulk(msg.Payload)
-
-		createdNotification := "\"created\":[]"
-		if len(created) > 0 {
-			createdNotification = fmt.Sprintf("\"created\": [\"%s\"]", strings.Join(created, "\",\""))
-		}
-
-		updatedNotification := "\"updated\":[]"
-		if len(updated) > 0 {
-			updatedNotification = fmt.Sprintf("\"updated\": [\"%s\"]", strings.Join(updated, "\",\""))
-		}
-
-		deletedNotification := "\"deleted\":[]"
-		if len(deleted) > 0 {
-			deletedNotification = fmt.Sprintf("\"deleted\": [\"%s\"]", strings.Join(deleted, "\",\""))
-		}
-
-		data := "{" + createdNotification + "," + updatedNotification + "," + deletedNotification + "}"
-		hmr.ps.notify(data)
+// setDiagnostics stores the diagnostics of source and reports whether they
+// need to be sent, clearing an already empty source is a no-op.
+func (hmr *hotModuleReplacer) setDiagnostics(source string, diagnostics []diagnostic.Diagnostic) bool {
+	hmr.mu.Lock()
+	defer hmr.mu.Unlock()
 
-		if len(routes) > 0 {
-			hmr.generateServeMux()
+	if len(diagnostics) == 0 {
+		if _, exists := hmr.diagnostics[source]; !exists {
+			return false
 		}
+		delete(hmr.diagnostics, source)
+		return true
+	}
 
-	case CreateFileType: // ` + CreateFileType.String() + `
-		filename := hmr.createFile(msg.Payload)
-		data := fmt.Sprintf("{ \"created\": [\"%s\"] }", filename)
-		hmr.ps.notify(data)
-
-	case UpdateFileType: // ` + UpdateFileType.String() + `
-		filename := hmr.updateFile(msg.Payload)
-		data := fmt.Sprintf("{ \"updated\": [\"%s\"] }", filename)
-		hmr.ps.notify(data)
+	hmr.diagnostics[source] = diagnostics
+	return true
+}
 
-	case DeleteFileType: // ` + DeleteFileType.String() + `
-		filename := hmr.deleteFile(msg.Payload)
-		data := fmt.Sprintf("{ \"deleted\": [\"%s\"] }", filename)
-		hmr.ps.notify(data)
+func (hmr *hotModuleReplacer) Send(msg *Message) {
+	c := changes{
+		files:  fileEvent(nil, nil, nil),
+		routes: routeEvent(nil, nil, nil),
+	}
+	hmr.apply(msg, &c)
 
-	case CreateRouteType: // ` + CreateRouteType.String() + `
-		hmr.createRoute(msg.Payload)
+	if c.remux {
 		hmr.generateServeMux()
-		// TODO: notify ServeNovaHMR
-
-	// TODO: udate route, just need to notify ServeNovaHMR
-	// NOTE: DO NOT generate server mux, it's not needed because the pattern is
-	//       registered already
+	}
 
-	case DeleteRouteType: // ` + DeleteRouteType.String() + `
-		hmr.deleteRoute(msg.Payload)
-		hmr.generateServeMux()
-		// TODO: notify ServeNovaHMR
+	for _, e := range c.diagnostics {
+		hmr.ps.notify(e)
 	}
-}
 
-func (hmr *hotModuleReplacer) serveNovaHMR(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	if !c.files.empty() {
+		hmr.ps.notify(c.files)
+	}
 
-	ch := make(chan string)
-	hmr.ps.subscribe(ch)
-	defer hmr.ps.unsubscribe(ch)
+	if !c.routes.empty() {
+		hmr.ps.notify(c.routes)
+	}
 
-	rc := http.NewResponseController(w)
+	for _, e := range c.reloads {
+		hmr.ps.notify(e)
+	}
 
-	ctx := r.Context()
-	for {
-		select {
-		case <-ctx.Done():
-			return
+	hmr.dashboard.changed()
+}
 
-		case data := <-ch:
-			_, err := fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
-			if err != nil {
-				return
-			}
-			err = rc.Flush()
-			if err != nil {
-				return
-			}
-		}
-	}
+func (hmr *hotModuleReplacer) close() {
+	hmr.closeOnce.Do(func() {
+		close(hmr.done)
+	})
 }
 
 func (hmr *hotModuleReplacer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -1,12 +1,6 @@
-(function () {
-  if (
-    window.__NOVA_HMR != null &&
-    window.__NOVA_HMR instanceof EventSource &&
-    window.__NOVA_HMR.readyState !== EventSource.CLOSED
-  ) {
-    return;
-  }
+const PROTOCOL_VERSION = 1;
 
+function setup() {
   function getScript(file) {
     const path = file.startsWith('/') ? file : '/' + file;
     const scripts = Array.from(document.getElementsByTagName('script'));
@@ -59,7 +53,7 @@
       next.onload = () => {
         console.log(`[reloaded] ${file}`);
       };
-      link.parentNode.replaceChild(next, link.nextSibling);
+      link.parentNode.replaceChild(next, link);
     });
   }
 
@@ -72,24 +66,402 @@
     });
   }
 
-  const sse = new EventSource('/@nova/hmr');
-  sse.addEventListener('change', (event) => {
-    const { created = [], deleted = [], updated = [] } = JSON.parse(event.data);
+  const diagnostics = {};
+  let overlay = null;
 
-    console.log('[hmr]', { created }, { deleted }, { updated });
-    if (
-      updated.some((file) => file.endsWith('.go') || file.endsWith('.html'))
-    ) {
-      // Hot Reloading
-      location.reload();
-    } else {
-      // Hot Module Replacement
-      updateScripts(updated.filter((file) => file.endsWith('.js')));
+  function escape(text) {
+    const div = document.createElement('div');
+    div.textContent = text;
+    return div.innerHTML;
+  }
+
+  function formatLocation(d) {
+    if (!d.file) {
+      return '';
+    }
+    return d.column > 0
+      ? `${d.file}:${d.line}:${d.column}`
+      : `${d.file}:${d.line}`;
+  }
+
+  function closeOverlay() {
+    if (overlay) {
+      overlay.remove();
+      overlay = null;
+    }
+  }
+
+  function renderOverlay() {
+    closeOverlay();
+
+    const all = Object.values(diagnostics).flat();
+    if (all.length === 0) {
+      return;
+    }
+
+    overlay = document.createElement('div');
+    overlay.id = '__nova-error-overlay';
+    overlay.style.cssText =
+      'position:fixed;inset:0;z-index:2147483647;overflow:auto;' +
+      'background:rgba(0,0,0,.85);color:#e8e8e8;padding:2rem;' +
+      'font:14px/1.5 ui-monospace,SFMono-Regular,Menlo,monospace;';
+    overlay.innerHTML =
+      '<button type="button" title="Dismiss" style="position:absolute;top:1rem;right:1rem;' +
+      'background:none;border:0;color:inherit;font-size:1.5rem;cursor:pointer">&times;</button>' +
+      all
+        .map(
+          (d) =>
+            '<section style="margin-bottom:1.5rem">' +
+            `<div style="color:#ff5555;font-weight:bold">[${escape(d.source)}] ${escape(d.message)}</div>` +
+            `<div style="color:#8be9fd">${escape(formatLocation(d))}</div>` +
+            (d.frame
+              ? `<pre style="background:#222;padding:1rem;overflow-x:auto">${escape(d.frame)}</pre>`
+              : '') +
+            '</section>'
+        )
+        .join('');
+    overlay.querySelector('button').addEventListener('click', closeOverlay);
+    document.body.appendChild(overlay);
+  }
+
+  const hotModules = new Map();
+
+  function modulePath(url) {
+    return new URL(url, location.href).pathname.replace(/^\/+/, '');
+  }
+
+  function linkStyle(style) {
+    if (getLink(style)) {
+      return;
+    }
+
+    const link = document.createElement('link');
+    link.rel = 'stylesheet';
+    link.href = style;
+    document.head.appendChild(link);
+  }
+
+  // createHotContext is called by every dev module as it is evaluated, style
+  // is the stylesheet of the CSS the module imports.
+  function createHotContext(url, style) {
+    const path = modulePath(url);
+    let record = hotModules.get(path);
+    if (!record) {
+      record = { data: {} };
+      hotModules.set(path, record);
+    }
+    if (style) {
+      linkStyle(style);
+    }
+
+    // a re-evaluated module registers its handlers again, data is kept
+    record.url = url.split('?')[0];
+    record.accepted = false;
+    record.acceptCallbacks = [];
+    record.disposeCallbacks = [];
+
+    return {
+      get data() {
+        return record.data;
+      },
+
+      accept(callback) {
+        record.accepted = true;
+        if (typeof callback === 'function') {
+          record.acceptCallbacks.push(callback);
+        }
+      },
+
+      dispose(callback) {
+        record.disposeCallbacks.push(callback);
+      },
+
+      invalidate() {
+        location.reload();
+      },
+    };
+  }
+
+  // boundaries walks up from an updated module through the loaded modules
+  // importing it until every path reaches a module accepting the update. It
+  // returns those modules and the ones walked through, or null when a path
+  // reaches a page script instead.
+  function boundaries(path, importers) {
+    const accepting = new Set();
+    const walked = new Set();
+    const queue = [path];
+
+    while (queue.length > 0) {
+      const current = queue.shift();
+      if (walked.has(current)) {
+        continue;
+      }
+      walked.add(current);
+
+      if (hotModules.get(current).accepted) {
+        accepting.add(current);
+        continue;
+      }
+
+      const loaded = (importers[current] || []).filter((p) => hotModules.has(p));
+      if (loaded.length === 0) {
+        return null;
+      }
+      queue.push(...loaded);
+    }
+
+    return { accepting, walked };
+  }
+
+  // updateModule evaluates again the modules accepting an update, the
+  // modules between them and the updated one come along since their imports
+  // are tagged with the build that changed them. It resolves to false when a
+  // path is not accepted.
+  async function updateModule({ path, importers = {} }) {
+    const found = boundaries(path, importers);
+    if (!found) {
+      return false;
+    }
+
+    const acceptors = [...found.accepting].map((p) => {
+      const record = hotModules.get(p);
+      return { url: record.url, callbacks: record.acceptCallbacks };
+    });
+
+    for (const p of found.walked) {
+      const record = hotModules.get(p);
+      for (const callback of record.disposeCallbacks) {
+        await callback(record.data);
+      }
+      record.disposeCallbacks = [];
+    }
+
+    const t = Date.now();
+    for (const acceptor of acceptors) {
+      const next = await import(acceptor.url + '?t=' + t);
+      acceptor.callbacks.forEach((callback) => callback(next));
+    }
+    console.log(`[hmr] updated ${path}`);
+    return true;
+  }
+
+  function updateModules(modules) {
+    // modules not loaded by this page have nothing to update
+    const loaded = modules.filter((m) => hotModules.has(m.path));
+    if (loaded.length === 0) {
+      return;
+    }
+
+    Promise.all(loaded.map(updateModule))
+      .then((accepted) => {
+        if (accepted.some((ok) => !ok)) {
+          location.reload();
+        }
+      })
+      .catch((err) => {
+        console.error('[hmr]', err);
+        location.reload();
+      });
+  }
+
+  function matchRoute(pattern, pathname) {
+    const space = pattern.indexOf(' ');
+    const path = space === -1 ? pattern : pattern.slice(space + 1).trim();
+    if (!path.startsWith('/')) {
+      // host patterns are not matched
+      return false;
+    }
+
+    const want = path.split('/');
+    const got = pathname.split('/');
+    for (let i = 0; i < want.length; i++) {
+      const segment = want[i];
+      if (segment === '{$}') {
+        return i === got.length - 1 && got[i] === '';
+      }
+      if (/^\{\w*\.\.\.\}$/.test(segment)) {
+        return true;
+      }
+      if (i === want.length - 1 && segment === '' && i > 0) {
+        // trailing slash matches the whole subtree
+        return i < got.length;
+      }
+      if (i >= got.length) {
+        return false;
+      }
+      if (/^\{\w*\}$/.test(segment)) {
+        if (got[i] === '') {
+          return false;
+        }
+        continue;
+      }
+      if (segment !== decodeURIComponent(got[i])) {
+        return false;
+      }
+    }
+    return want.length === got.length;
+  }
+
+  const handlers = {
+    connected(event) {
+      if (event.version !== PROTOCOL_VERSION) {
+        console.warn(
+          `[hmr] protocol version ${event.version} is not supported, expected ${PROTOCOL_VERSION}`
+        );
+      }
+    },
+
+    file({ created = [], updated = [], deleted = [], modules = [] }) {
+      console.log('[hmr]', { created }, { updated }, { deleted });
+
+      updateModules(modules);
+
+      // the importers of a module only changed to import its new code
+      const hot = new Set(modules.flatMap((m) => Object.keys(m.importers || {})));
+      updateScripts(
+        updated.filter((file) => file.endsWith('.js') && !hot.has(file))
+      );
       updateLinks(updated.filter((file) => file.endsWith('.css')));
+
+      deleteScripts(deleted.filter((file) => file.endsWith('.js')));
+      deleteLinks(deleted.filter((file) => file.endsWith('.css')));
+    },
+
+    route({ created = [], updated = [], deleted = [] }) {
+      const patterns = [...created, ...updated, ...deleted];
+      if (patterns.some((p) => matchRoute(p, location.pathname))) {
+        location.reload();
+      }
+    },
+
+    diagnostic({ source, diagnostics: list = [] }) {
+      if (list.length > 0) {
+        diagnostics[source] = list;
+        list.forEach((d) =>
+          console.error(`[${d.source}] ${formatLocation(d)} ${d.message}`)
+        );
+      } else {
+        delete diagnostics[source];
+      }
+
+      renderOverlay();
+    },
+
+    reload({ reason }) {
+      console.log('[hmr] reload', reason);
+      location.reload();
+    },
+  };
+
+  const client = {
+    transport: null,
+    lastEventId: '',
+    socket: null,
+
+    send(message) {
+      if (this.socket && this.socket.readyState === WebSocket.OPEN) {
+        this.socket.send(JSON.stringify(message));
+      } else if (message.kind !== 'ack') {
+        fetch('/@nova/hmr', {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify(message),
+          keepalive: true,
+        }).catch(() => {});
+      }
+    },
+  };
+
+  function dispatch(event, id) {
+    const handler = handlers[event.kind];
+    if (handler) {
+      handler(event);
+    }
+
+    if (id) {
+      client.lastEventId = id;
+      client.send({ kind: 'ack', id });
     }
+  }
+
+  function connectEventSource() {
+    const query = client.lastEventId
+      ? '?lastEventId=' + encodeURIComponent(client.lastEventId)
+      : '';
+    const sse = new EventSource('/@nova/hmr' + query);
+    Object.keys(handlers).forEach((kind) => {
+      sse.addEventListener(kind, (event) =>
+        dispatch(JSON.parse(event.data), event.lastEventId)
+      );
+    });
+    client.transport = 'sse';
+  }
+
+  function connectWebSocket(fallback) {
+    const protocol = location.protocol === 'https:' ? 'wss:' : 'ws:';
+    const query = client.lastEventId
+      ? '?lastEventId=' + encodeURIComponent(client.lastEventId)
+      : '';
+    const ws = new WebSocket(`${protocol}//${location.host}/@nova/hmr/ws${query}`);
+
+    let opened = false;
+    const timeout = setTimeout(() => ws.close(), 3000);
+
+    ws.addEventListener('open', () => {
+      opened = true;
+      clearTimeout(timeout);
+      client.socket = ws;
+      client.transport = 'websocket';
+    });
+
+    ws.addEventListener('message', (message) => {
+      const event = JSON.parse(message.data);
+      dispatch(event, event.id);
+    });
 
-    deleteScripts(deleted.filter((file) => file.endsWith('.js')));
-    deleteLinks(updated.filter((file) => file.endsWith('.css')));
+    ws.addEventListener('close', () => {
+      clearTimeout(timeout);
+      client.socket = null;
+      if (opened) {
+        setTimeout(() => connectWebSocket(connectEventSource), 1000);
+      } else {
+        console.warn('[hmr] websocket unavailable, falling back to event stream');
+        fallback();
+      }
+    });
+  }
+
+  window.addEventListener('error', (event) => {
+    client.send({
+      kind: 'error',
+      message: event.message,
+      stack: event.error && event.error.stack,
+      url: event.filename || location.href,
+    });
   });
-  window.__NOVA_HMR = sse;
-})();
+
+  window.addEventListener('unhandledrejection', (event) => {
+    const reason = event.reason;
+    client.send({
+      kind: 'error',
+      message: 'Unhandled rejection: ' + (reason && reason.message ? reason.message : String(reason)),
+      stack: reason && reason.stack,
+      url: location.href,
+    });
+  });
+
+  if (localStorage.getItem('nova:hmr-transport') === 'sse' || !('WebSocket' in window)) {
+    connectEventSource();
+  } else {
+    connectWebSocket(connectEventSource);
+  }
+
+  client.createHotContext = createHotContext;
+  return client;
+}
+
+const client = window.__NOVA_HMR || (window.__NOVA_HMR = setup());
+
+export function createHotContext(url, style) {
+  return client.createHotContext(url, style);
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/sgq995/nova/internal/config"
+)
+
+// knownHost reports whether browsers may reach the dev server by hostname:
+// localhost and its subdomains, or a certificate host.
+func knownHost(c *config.Config, hostname string) bool {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	return strings.HasSuffix(hostname, ".localhost") || certificateHost(c, hostname)
+}
+
+// certificateHost reports whether a certificate may be issued for hostname:
+// localhost, server.host, one of server.allowedHosts or an address of this
+// machine. It is a closed list, unlike the subdomains of localhost, so
+// clients can't fill the disk with leaves.
+func certificateHost(c *config.Config, hostname string) bool {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	if hostname == "localhost" {
+		return true
+	}
+	if hostname == strings.ToLower(c.Server.Host) || slices.Contains(c.Server.AllowedHosts, hostname) {
+		return true
+	}
+
+	ip := net.ParseIP(hostname)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(addrs, func(addr net.Addr) bool {
+		ipNet, ok := addr.(*net.IPNet)
+		return ok && ipNet.IP.Equal(ip)
+	})
+}
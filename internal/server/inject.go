@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+const hmrScriptTag = `<script type="module" src="/@nova/hmr.js"></script>`
+
+var headEnd = []byte("</head>")
+
+// injectWriter copies an HTML response, adding snippet right before
+// </head>. The closing tag may be split across writes, so the bytes that could
+// start it are held back until the next write or flush.
+type injectWriter struct {
+	http.ResponseWriter
+	snippet []byte
+
+	// a 200 without Content-Type is held until the body can be sniffed
+	status      int
+	wroteHeader bool
+
+	decided  bool
+	active   bool
+	injected bool
+	held     []byte
+}
+
+func (iw *injectWriter) decide(statusCode int, b []byte) {
+	if iw.decided {
+		return
+	}
+	iw.decided = true
+
+	h := iw.Header()
+	if statusCode != http.StatusOK || h.Get("Content-Encoding") != "" {
+		return
+	}
+
+	contentType := h.Get("Content-Type")
+	if contentType == "" && b != nil {
+		// set it now, net/http would sniff the rewritten body otherwise
+		contentType = http.DetectContentType(b)
+		h.Set("Content-Type", contentType)
+	}
+	if !strings.HasPrefix(contentType, "text/html") {
+		return
+	}
+
+	iw.active = true
+	h.Del("Content-Length")
+}
+
+func (iw *injectWriter) WriteHeader(statusCode int) {
+	if statusCode < http.StatusOK {
+		iw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if iw.wroteHeader || iw.status != 0 {
+		return
+	}
+
+	if statusCode == http.StatusOK && iw.Header().Get("Content-Type") == "" {
+		iw.status = statusCode
+		return
+	}
+
+	iw.decide(statusCode, nil)
+	iw.wroteHeader = true
+	iw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (iw *injectWriter) Write(b []byte) (int, error) {
+	if !iw.wroteHeader {
+		iw.decide(http.StatusOK, b)
+		iw.wroteHeader = true
+		iw.ResponseWriter.WriteHeader(http.StatusOK)
+	}
+	if !iw.active || iw.injected {
+		return iw.ResponseWriter.Write(b)
+	}
+
+	data := append(iw.held, b...)
+	iw.held = nil
+
+	if i := indexFold(data, headEnd); i >= 0 {
+		iw.injected = true
+		_, err := iw.writeAll(data[:i], iw.snippet, data[i:])
+		return len(b), err
+	}
+
+	// keep what could be the start of a split </head>
+	keep := partialSuffix(data, headEnd)
+	iw.held = bytes.Clone(data[len(data)-keep:])
+	_, err := iw.ResponseWriter.Write(data[:len(data)-keep])
+	return len(b), err
+}
+
+// FlushError sends what was written so far, but for a trailing "</he" and
+// the like which the next write may complete. Streamed pages would stall
+// until the end otherwise. A header waiting for the body to be sniffed is
+// kept until the first write, there is nothing to send before it anyway.
+func (iw *injectWriter) FlushError() error {
+	if !iw.wroteHeader {
+		if iw.Header().Get("Content-Type") == "" {
+			return nil
+		}
+		iw.Write(nil)
+	}
+	if len(iw.held) > 0 {
+		keep := partialSuffix(iw.held, headEnd)
+		_, err := iw.ResponseWriter.Write(iw.held[:len(iw.held)-keep])
+		if err != nil {
+			return err
+		}
+		iw.held = iw.held[len(iw.held)-keep:]
+	}
+	return http.NewResponseController(iw.ResponseWriter).Flush()
+}
+
+func (iw *injectWriter) Flush() {
+	iw.FlushError()
+}
+
+func (iw *injectWriter) writeAll(chunks ...[]byte) (int, error) {
+	n := 0
+	for _, chunk := range chunks {
+		m, err := iw.ResponseWriter.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// finish writes what was held back, documents without </head> get the
+// snippet at the end.
+func (iw *injectWriter) finish() {
+	if !iw.wroteHeader && iw.status != 0 {
+		iw.decide(iw.status, nil)
+		iw.wroteHeader = true
+		iw.ResponseWriter.WriteHeader(iw.status)
+	}
+	if !iw.active || iw.injected {
+		return
+	}
+	iw.injected = true
+	iw.writeAll(iw.held, iw.snippet)
+	iw.held = nil
+}
+
+func (iw *injectWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
+
+// partialSuffix returns the length of the longest end of s that starts sep,
+// ignoring case.
+func partialSuffix(s, sep []byte) int {
+	for n := min(len(s), len(sep)-1); n > 0; n-- {
+		if bytes.EqualFold(s[len(s)-n:], sep[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+func indexFold(s, sep []byte) int {
+	for i := 0; i+len(sep) <= len(s); i++ {
+		if bytes.EqualFold(s[i:i+len(sep)], sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+// injectHMR adds the HMR client to every HTML page served by next, so pages
+// without scripts live-reload too. It is only used by the dev server.
+func injectHMR(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		iw := &injectWriter{ResponseWriter: w, snippet: []byte(hmrScriptTag)}
+		next.ServeHTTP(iw, r)
+		iw.finish()
+	})
+}
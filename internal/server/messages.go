@@ -1,6 +1,10 @@
 package server
 
-import "strconv"
+import (
+	"strconv"
+
+	"github.com/sgq995/nova/internal/diagnostic"
+)
 
 type MessageType int
 
@@ -11,8 +15,16 @@ const (
 	UpdateFileType
 	DeleteFileType
 
+	UpdateModuleType
+
 	CreateRouteType
 	DeleteRouteType
+
+	DiagnosticType
+
+	ReloadType
+
+	UpdateRouteType
 )
 
 func (t MessageType) Int() int {
@@ -37,12 +49,24 @@ func (t MessageType) String() string {
 	case DeleteFileType:
 		return "DeleteFileType"
 
+	case UpdateModuleType:
+		return "UpdateModuleType"
+
 	case CreateRouteType:
 		return "CreateRouteType"
 
 	case DeleteRouteType:
 		return "DeleteRouteType"
 
+	case DiagnosticType:
+		return "DiagnosticType"
+
+	case ReloadType:
+		return "ReloadType"
+
+	case UpdateRouteType:
+		return "UpdateRouteType"
+
 	default:
 		return ""
 	}
@@ -91,9 +115,37 @@ func DeleteFileMessage(filename string) *Message {
 	}
 }
 
-func CreateRouteMessage(pattern string) *Message {
+// UpdateModuleMessage updates a JavaScript module whose own code changed,
+// importers links it to the modules that import it, directly or not, so
+// clients can find the ones accepting the update.
+func UpdateModuleMessage(filename string, contents []byte, importers map[string][]string) *Message {
+	return &Message{
+		Type: UpdateModuleType,
+		Payload: map[string]any{
+			"filename":  filename,
+			"contents":  contents,
+			"importers": importers,
+		},
+	}
+}
+
+// CreateRouteMessage registers pattern, module is the generated main.go that
+// serves it or empty for routes served by the dev application.
+func CreateRouteMessage(pattern string, module string) *Message {
 	return &Message{
 		Type: CreateRouteType,
+		Payload: map[string]any{
+			"pattern": pattern,
+			"module":  module,
+		},
+	}
+}
+
+// UpdateRouteMessage tells clients showing pattern that its output changed
+// without the route itself changing, i.e. one of its templates was edited.
+func UpdateRouteMessage(pattern string) *Message {
+	return &Message{
+		Type: UpdateRouteType,
 		Payload: map[string]any{
 			"pattern": pattern,
 		},
@@ -108,3 +160,24 @@ func DeleteRouteMessage(pattern string) *Message {
 		},
 	}
 }
+
+// DiagnosticMessage replaces the diagnostics reported by source, an empty
+// list clears them.
+func DiagnosticMessage(source string, diagnostics []diagnostic.Diagnostic) *Message {
+	return &Message{
+		Type: DiagnosticType,
+		Payload: map[string]any{
+			"source":      source,
+			"diagnostics": diagnostics,
+		},
+	}
+}
+
+func ReloadMessage(reason string) *Message {
+	return &Message{
+		Type: ReloadType,
+		Payload: map[string]any{
+			"reason": reason,
+		},
+	}
+}
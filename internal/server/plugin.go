@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sgq995/nova/internal/logger"
+	"github.com/sgq995/nova/plugin"
+)
+
+// mountPlugins registers the routes contributed by plugins, a route
+// conflicting with another one is reported and skipped.
+func mountPlugins(mux *http.ServeMux) {
+	for _, p := range plugin.Hooks[plugin.Router]() {
+		for _, route := range p.Routes() {
+			err := handle(mux, route.Pattern, route.Handler)
+			if err != nil {
+				logger.Errorf("plugin %s: %+v", p.Name(), err)
+				continue
+			}
+			logger.Debugf("[server] plugin %s handles %s", p.Name(), route.Pattern)
+		}
+	}
+}
+
+// handle is mux.Handle returning invalid and conflicting patterns as errors.
+func handle(mux *http.ServeMux, pattern string, handler http.Handler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("nova: %v", r)
+		}
+	}()
+
+	mux.Handle(pattern, handler)
+	return nil
+}
+
+// pluginMiddleware wraps handler with the middlewares of plugins, the first
+// registered plugin ends up outermost.
+func pluginMiddleware(handler http.Handler) http.Handler {
+	middlewares := plugin.Hooks[plugin.Middleware]()
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i].Middleware(handler)
+	}
+	return handler
+}
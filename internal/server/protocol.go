@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/sgq995/nova/internal/diagnostic"
+)
+
+// ProtocolVersion is bumped on every breaking change of the HMR wire format.
+const ProtocolVersion = 1
+
+// EventKind identifies an HMR event. Over SSE it is also used as the event
+// name, so clients may either listen per kind or read Event.Kind.
+type EventKind string
+
+const (
+	// ConnectedEvent is the first event of every stream.
+	ConnectedEvent EventKind = "connected"
+
+	// FileEvent reports in-memory files (bundles, stylesheets, assets) by
+	// their URL path relative to the server root.
+	FileEvent EventKind = "file"
+
+	// RouteEvent reports route patterns, i.e. "GET /users/{id}".
+	RouteEvent EventKind = "route"
+
+	// DiagnosticEvent replaces every diagnostic of Source, an empty list
+	// clears them.
+	DiagnosticEvent EventKind = "diagnostic"
+
+	// ReloadEvent asks every client to perform a full page reload.
+	ReloadEvent EventKind = "reload"
+
+	// DashboardEvent tells dashboard clients, those connected with the
+	// dashboard query parameter, that /@nova/api/state changed.
+	DashboardEvent EventKind = "dashboard"
+)
+
+// Event is the JSON document sent to HMR clients. Fields that don't apply to
+// Kind are omitted.
+type Event struct {
+	Version int       `json:"version"`
+	Kind    EventKind `json:"kind"`
+
+	Created []string `json:"created,omitempty"`
+	Updated []string `json:"updated,omitempty"`
+	Deleted []string `json:"deleted,omitempty"`
+
+	Modules []ModuleUpdate `json:"modules,omitempty"`
+
+	Source      string                  `json:"source,omitempty"`
+	Diagnostics []diagnostic.Diagnostic `json:"diagnostics,omitempty"`
+
+	Reason string `json:"reason,omitempty"`
+}
+
+// ModuleUpdate describes an updated JavaScript module of a file event.
+// Importers maps the module and every module importing it, directly or not,
+// to their direct importers, up to the entry points.
+type ModuleUpdate struct {
+	Path      string              `json:"path"`
+	Importers map[string][]string `json:"importers"`
+}
+
+func newEvent(kind EventKind) *Event {
+	return &Event{
+		Version: ProtocolVersion,
+		Kind:    kind,
+	}
+}
+
+func connectedEvent() *Event {
+	return newEvent(ConnectedEvent)
+}
+
+func fileEvent(created, updated, deleted []string) *Event {
+	e := newEvent(FileEvent)
+	e.Created = created
+	e.Updated = updated
+	e.Deleted = deleted
+	return e
+}
+
+func routeEvent(created, updated, deleted []string) *Event {
+	e := newEvent(RouteEvent)
+	e.Created = created
+	e.Updated = updated
+	e.Deleted = deleted
+	return e
+}
+
+func diagnosticEvent(source string, diagnostics []diagnostic.Diagnostic) *Event {
+	e := newEvent(DiagnosticEvent)
+	e.Source = source
+	e.Diagnostics = diagnostics
+	return e
+}
+
+func reloadEvent(reason string) *Event {
+	e := newEvent(ReloadEvent)
+	e.Reason = reason
+	return e
+}
+
+func dashboardEvent() *Event {
+	return newEvent(DashboardEvent)
+}
+
+func (e *Event) empty() bool {
+	return len(e.Created) == 0 && len(e.Updated) == 0 && len(e.Deleted) == 0
+}
+
+func (e *Event) encode() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// ClientMessageKind identifies a message sent by an HMR client.
+type ClientMessageKind string
+
+const (
+	// AckMessage acknowledges the event with ID once it has been applied.
+	AckMessage ClientMessageKind = "ack"
+
+	// ErrorMessage reports an uncaught error from the browser.
+	ErrorMessage ClientMessageKind = "error"
+)
+
+// ClientMessage is the JSON document HMR clients send over the WebSocket
+// channel, or as a POST request to the SSE endpoint.
+type ClientMessage struct {
+	Kind ClientMessageKind `json:"kind"`
+	ID   string            `json:"id,omitempty"`
+
+	Message string `json:"message,omitempty"`
+	Stack   string `json:"stack,omitempty"`
+	URL     string `json:"url,omitempty"`
+}
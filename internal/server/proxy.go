@@ -0,0 +1,189 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sgq995/nova/internal/config"
+	"github.com/sgq995/nova/internal/diagnostic"
+	"github.com/sgq995/nova/internal/logger"
+)
+
+type pathRewrite struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// devProxy forwards a path prefix to another backend, see server.proxy.
+type devProxy struct {
+	prefix string
+	target *url.URL
+	config config.ProxyConfig
+
+	rewrites []pathRewrite
+
+	proxy  *httputil.ReverseProxy
+	report reportFunc
+
+	// failing is set by errors and cleared, and reported, by the next
+	// response of the target
+	failing atomic.Bool
+}
+
+func newDevProxy(prefix string, c config.ProxyConfig, report reportFunc) (*devProxy, error) {
+	if !strings.HasPrefix(prefix, "/") || strings.TrimSuffix(prefix, "/") == "" {
+		return nil, fmt.Errorf("nova: proxy %q: the prefix must be a path below /", prefix)
+	}
+
+	target, err := url.Parse(c.Target)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("nova: proxy %s: invalid target %q", prefix, c.Target)
+	}
+
+	p := &devProxy{
+		prefix: prefix,
+		target: target,
+		config: c,
+		report: report,
+	}
+
+	for _, pattern := range slices.Sorted(maps.Keys(c.Rewrite)) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("nova: proxy %s: %w", prefix, err)
+		}
+		p.rewrites = append(p.rewrites, pathRewrite{re: re, replacement: c.Rewrite[pattern]})
+	}
+
+	p.proxy = &httputil.ReverseProxy{
+		Rewrite:        p.rewrite,
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.errorHandler,
+	}
+
+	return p, nil
+}
+
+// patterns are the ServeMux patterns of the prefix, with and without the
+// trailing slash.
+func (p *devProxy) patterns() []string {
+	prefix := strings.TrimSuffix(p.prefix, "/")
+	return []string{prefix, prefix + "/"}
+}
+
+func (p *devProxy) source() string {
+	return diagnostic.SourceProxy + " " + p.prefix
+}
+
+func (p *devProxy) rewrite(pr *httputil.ProxyRequest) {
+	path := pr.In.URL.Path
+	for _, rw := range p.rewrites {
+		path = rw.re.ReplaceAllString(path, rw.replacement)
+	}
+	pr.Out.URL.Path = path
+	pr.Out.URL.RawPath = ""
+
+	pr.SetURL(p.target)
+	pr.SetXForwarded()
+
+	if p.config.ChangeOrigin {
+		if pr.Out.Header.Get("Origin") != "" {
+			pr.Out.Header.Set("Origin", p.target.Scheme+"://"+p.target.Host)
+		}
+	} else {
+		pr.Out.Host = pr.In.Host
+	}
+
+	for key, value := range p.config.Headers {
+		pr.Out.Header.Set(key, value)
+	}
+}
+
+func (p *devProxy) modifyResponse(*http.Response) error {
+	if p.failing.CompareAndSwap(true, false) {
+		p.report(p.source(), nil)
+	}
+	return nil
+}
+
+func (p *devProxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	logger.Errorf("[proxy] %s %s: %+v", r.Method, r.URL.Path, err)
+
+	diagnostics := []diagnostic.Diagnostic{{
+		Source:  diagnostic.SourceProxy,
+		Message: fmt.Sprintf("%s %s -> %s: %s", r.Method, r.URL.Path, p.target, err),
+	}}
+	p.failing.Store(true)
+	p.report(p.source(), diagnostics)
+
+	writeDebugPage(w, &debugInfo{
+		Status:      http.StatusBadGateway,
+		Title:       "proxy error",
+		Diagnostics: diagnostics,
+		Request:     r,
+	})
+}
+
+func (p *devProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.config.WS && headerContainsToken(r.Header, "Connection", "upgrade") {
+		http.Error(w, "nova: websocket upgrades are disabled for proxy "+p.prefix+", set \"ws\": true", http.StatusBadGateway)
+		return
+	}
+
+	p.proxy.ServeHTTP(w, r)
+}
+
+// reservedPrefixes are served by nova itself, proxies can't take them over.
+var reservedPrefixes = []string{"/@nova/", "/@node_modules/"}
+
+// mountProxies registers the server.proxy rules on mux. Invalid rules and
+// prefixes taken by nova or another rule are returned as config errors.
+func mountProxies(mux *http.ServeMux, rules map[string]config.ProxyConfig, report reportFunc) error {
+	errs := []error{}
+	mounted := map[string]string{}
+	for _, prefix := range slices.Sorted(maps.Keys(rules)) {
+		p, err := newDevProxy(prefix, rules[prefix], report)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		clean := strings.TrimSuffix(prefix, "/") + "/"
+		if i := slices.IndexFunc(reservedPrefixes, func(reserved string) bool {
+			return strings.HasPrefix(clean, reserved) || strings.HasPrefix(reserved, clean)
+		}); i != -1 {
+			errs = append(errs, fmt.Errorf("nova: proxy %s: %s is served by nova", prefix, reservedPrefixes[i]))
+			continue
+		}
+		if other, exists := mounted[clean]; exists {
+			errs = append(errs, fmt.Errorf("nova: proxy %s: same prefix as proxy %s", prefix, other))
+			continue
+		}
+		mounted[clean] = prefix
+
+		for _, pattern := range p.patterns() {
+			err = handle(mux, pattern, p)
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("nova: proxy %s: %w", prefix, err))
+			continue
+		}
+
+		logger.Infof("proxy %s -> %s", prefix, p.target)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/sgq995/nova/internal/config"
+	"github.com/sgq995/nova/internal/diagnostic"
+	"github.com/sgq995/nova/internal/must"
+)
+
+// upstreamRequest is what the upstream of a proxy received.
+type upstreamRequest struct {
+	path   string
+	host   string
+	header http.Header
+}
+
+func TestDevProxy(t *testing.T) {
+	received := make(chan upstreamRequest, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- upstreamRequest{path: r.URL.Path, host: r.Host, header: r.Header.Clone()}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+	upstreamHost := must.Must(url.Parse(upstream.URL)).Host
+
+	tests := []struct {
+		name   string
+		prefix string
+		config config.ProxyConfig
+		path   string
+		header http.Header
+		status int
+		check  func(t *testing.T, got upstreamRequest) // nil when the upstream must not be reached
+	}{
+		{
+			name:   "prefix",
+			prefix: "/api",
+			path:   "/api/users?page=2",
+			status: http.StatusNoContent,
+			check: func(t *testing.T, got upstreamRequest) {
+				if got.path != "/api/users" {
+					t.Errorf("path = %q, want /api/users", got.path)
+				}
+			},
+		},
+		{
+			name:   "prefix root",
+			prefix: "/api/",
+			path:   "/api",
+			status: http.StatusNoContent,
+			check: func(t *testing.T, got upstreamRequest) {
+				if got.path != "/api" {
+					t.Errorf("path = %q, want /api", got.path)
+				}
+			},
+		},
+		{
+			name:   "rewrites in key order",
+			prefix: "/api",
+			config: config.ProxyConfig{Rewrite: map[string]string{
+				"^/v1":  "/v2",
+				"^/api": "/v1",
+			}},
+			path:   "/api/users",
+			status: http.StatusNoContent,
+			check: func(t *testing.T, got upstreamRequest) {
+				if got.path != "/v2/users" {
+					t.Errorf("path = %q, want /v2/users", got.path)
+				}
+			},
+		},
+		{
+			name:   "headers",
+			prefix: "/api",
+			config: config.ProxyConfig{Headers: map[string]string{"Authorization": "Bearer dev"}},
+			path:   "/api",
+			header: http.Header{"Authorization": {"Bearer client"}},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, got upstreamRequest) {
+				if auth := got.header.Values("Authorization"); len(auth) != 1 || auth[0] != "Bearer dev" {
+					t.Errorf("Authorization = %q, want [Bearer dev]", auth)
+				}
+				if got.header.Get("X-Forwarded-Host") != "example.com" {
+					t.Errorf("X-Forwarded-Host = %q, want example.com", got.header.Get("X-Forwarded-Host"))
+				}
+			},
+		},
+		{
+			name:   "host kept",
+			prefix: "/api",
+			path:   "/api",
+			header: http.Header{"Origin": {"http://example.com"}},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, got upstreamRequest) {
+				if got.host != "example.com" {
+					t.Errorf("Host = %q, want example.com", got.host)
+				}
+				if got.header.Get("Origin") != "http://example.com" {
+					t.Errorf("Origin = %q, want http://example.com", got.header.Get("Origin"))
+				}
+			},
+		},
+		{
+			name:   "change origin",
+			prefix: "/api",
+			config: config.ProxyConfig{ChangeOrigin: true},
+			path:   "/api",
+			header: http.Header{"Origin": {"http://example.com"}},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, got upstreamRequest) {
+				if got.host != upstreamHost {
+					t.Errorf("Host = %q, want %q", got.host, upstreamHost)
+				}
+				if got.header.Get("Origin") != upstream.URL {
+					t.Errorf("Origin = %q, want %q", got.header.Get("Origin"), upstream.URL)
+				}
+			},
+		},
+		{
+			name:   "websocket refused",
+			prefix: "/api",
+			path:   "/api/socket",
+			header: http.Header{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}},
+			status: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.config
+			c.Target = upstream.URL
+
+			mux := http.NewServeMux()
+			err := mountProxies(mux, map[string]config.ProxyConfig{tt.prefix: c}, func(string, []diagnostic.Diagnostic) {})
+			if err != nil {
+				t.Fatalf("mountProxies: %v", err)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for key, values := range tt.header {
+				r.Header[key] = values
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+
+			select {
+			case got := <-received:
+				if tt.check == nil {
+					t.Errorf("the upstream got %s", got.path)
+					return
+				}
+				tt.check(t, got)
+
+			default:
+				if tt.check != nil {
+					t.Error("the upstream got nothing")
+				}
+			}
+		})
+	}
+}
+
+func TestDevProxyUnreachable(t *testing.T) {
+	// a port nothing listens on, until the upstream starts
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	var mu sync.Mutex
+	reported := map[string][]diagnostic.Diagnostic{}
+	report := func(source string, diagnostics []diagnostic.Diagnostic) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported[source] = diagnostics
+	}
+	diagnostics := func() ([]diagnostic.Diagnostic, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		d, exists := reported[diagnostic.SourceProxy+" /api"]
+		return d, exists
+	}
+
+	p, err := newDevProxy("/api", config.ProxyConfig{Target: "http://" + addr}, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+	if d, _ := diagnostics(); len(d) == 0 {
+		t.Fatal("an unreachable upstream reported nothing")
+	}
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("port taken meanwhile: %v", err)
+	}
+	upstream := &httptest.Server{
+		Listener: l,
+		Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})},
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if d, exists := diagnostics(); !exists || len(d) > 0 {
+		t.Errorf("diagnostics = %v, want them cleared", d)
+	}
+}
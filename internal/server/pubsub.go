@@ -0,0 +1,222 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	clientQueueSize = 64
+	replaySize      = 256
+)
+
+// envelope is an event tagged with the id clients use to resume a stream.
+type envelope struct {
+	id    uint64
+	event *Event
+}
+
+// subscriber buffers the events of a single client. Events are delivered in
+// publish order, when the client falls behind the oldest ones are dropped and
+// the client is told to reload.
+type subscriber struct {
+	info clientInfo
+
+	mu         sync.Mutex
+	queue      []envelope
+	size       int // how many events queue holds, the replay included
+	overflowed bool
+
+	ready chan struct{}
+}
+
+// clientInfo describes a connected HMR client for the dashboard.
+type clientInfo struct {
+	ID         uint64    `json:"id"`
+	Transport  string    `json:"transport"`
+	RemoteAddr string    `json:"remoteAddr"`
+	UserAgent  string    `json:"userAgent"`
+	Since      time.Time `json:"since"`
+
+	// Dashboard clients also receive dashboard events
+	Dashboard bool `json:"dashboard"`
+}
+
+func newSubscriber(info clientInfo, replay []envelope) *subscriber {
+	sub := &subscriber{
+		info:  info,
+		queue: make([]envelope, 0, clientQueueSize+len(replay)),
+		size:  clientQueueSize + len(replay),
+		ready: make(chan struct{}, 1),
+	}
+	sub.queue = append(sub.queue, replay...)
+	if len(replay) > 0 {
+		sub.ready <- struct{}{}
+	}
+	return sub
+}
+
+func (sub *subscriber) push(env envelope) {
+	sub.mu.Lock()
+	if len(sub.queue) >= sub.size {
+		sub.queue = append(sub.queue[:0], sub.queue[1:]...)
+		sub.overflowed = true
+	}
+	sub.queue = append(sub.queue, env)
+	sub.mu.Unlock()
+
+	select {
+	case sub.ready <- struct{}{}:
+	default:
+	}
+}
+
+// drain returns the pending events and whether some of them were dropped
+// since the last call.
+func (sub *subscriber) drain() ([]envelope, bool) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	queue := sub.queue
+	overflowed := sub.overflowed
+	sub.queue = make([]envelope, 0, clientQueueSize)
+	sub.size = clientQueueSize
+	sub.overflowed = false
+	return queue, overflowed
+}
+
+type pubSub struct {
+	// epoch tells event ids of different dev server runs apart
+	epoch string
+
+	mu     sync.Mutex
+	lastID uint64
+	replay []envelope // ring buffer of the last replaySize events
+	subs   map[*subscriber]struct{}
+
+	lastClientID uint64
+}
+
+func newPubSub() *pubSub {
+	return &pubSub{
+		epoch:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		replay: make([]envelope, 0, replaySize),
+		subs:   make(map[*subscriber]struct{}),
+	}
+}
+
+func (ps *pubSub) notify(e *Event) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.lastID++
+	env := envelope{id: ps.lastID, event: e}
+
+	if len(ps.replay) < replaySize {
+		ps.replay = append(ps.replay, env)
+	} else {
+		ps.replay[int((env.id-1)%replaySize)] = env
+	}
+
+	for sub := range ps.subs {
+		sub.push(env)
+	}
+}
+
+// notifyDashboard queues e for dashboard clients only. It has no id and
+// isn't replayed, dashboards fetch the whole state again anyway.
+func (ps *pubSub) notifyDashboard(e *Event) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for sub := range ps.subs {
+		if sub.info.Dashboard {
+			sub.push(envelope{event: e})
+		}
+	}
+}
+
+// subscribe registers a new subscriber. When lastEventID is set, the events
+// published after it are queued first. It reports false when the missed
+// events are no longer available, in which case the client must reload.
+func (ps *pubSub) subscribe(lastEventID string, info clientInfo) (*subscriber, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.lastClientID++
+	info.ID = ps.lastClientID
+	info.Since = time.Now()
+
+	missed, ok := ps.missed(lastEventID)
+	sub := newSubscriber(info, missed)
+	ps.subs[sub] = struct{}{}
+
+	return sub, ok
+}
+
+// missed returns the events published after lastEventID, the queue of a
+// subscriber grows to hold all of them. It reports false when some are no
+// longer available.
+func (ps *pubSub) missed(lastEventID string) ([]envelope, bool) {
+	if lastEventID == "" {
+		return nil, true
+	}
+
+	epoch, id, ok := ps.parseID(lastEventID)
+	if !ok || epoch != ps.epoch || id > ps.lastID {
+		return nil, false
+	}
+
+	oldest := ps.lastID - uint64(len(ps.replay)) + 1
+	if id+1 < oldest {
+		return nil, false
+	}
+
+	missed := []envelope{}
+	for next := id + 1; next <= ps.lastID; next++ {
+		missed = append(missed, ps.replay[int((next-1)%replaySize)])
+	}
+	return missed, true
+}
+
+func (ps *pubSub) unsubscribe(sub *subscriber) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	delete(ps.subs, sub)
+}
+
+func (ps *pubSub) clients() []clientInfo {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	clients := make([]clientInfo, 0, len(ps.subs))
+	for sub := range ps.subs {
+		clients = append(clients, sub.info)
+	}
+	slices.SortFunc(clients, func(a, b clientInfo) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return clients
+}
+
+func (ps *pubSub) formatID(id uint64) string {
+	return fmt.Sprintf("%s.%d", ps.epoch, id)
+}
+
+func (ps *pubSub) parseID(s string) (string, uint64, bool) {
+	epoch, n, found := strings.Cut(s, ".")
+	if !found {
+		return "", 0, false
+	}
+	id, err := strconv.ParseUint(n, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return epoch, id, true
+}
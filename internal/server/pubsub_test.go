@@ -0,0 +1,167 @@
+package server
+
+import (
+	"slices"
+	"testing"
+)
+
+func envelopeIDs(envs []envelope) []uint64 {
+	ids := []uint64{}
+	for _, env := range envs {
+		ids = append(ids, env.id)
+	}
+	return ids
+}
+
+func idRange(first, last uint64) []uint64 {
+	ids := []uint64{}
+	for id := first; id <= last; id++ {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestPubSubSubscribe(t *testing.T) {
+	tests := []struct {
+		name        string
+		published   int
+		lastEventID func(ps *pubSub) string
+		ok          bool
+		replayed    []uint64
+	}{
+		{
+			name:        "new client",
+			published:   10,
+			lastEventID: func(ps *pubSub) string { return "" },
+			ok:          true,
+			replayed:    []uint64{},
+		},
+		{
+			name:        "up to date",
+			published:   10,
+			lastEventID: func(ps *pubSub) string { return ps.formatID(10) },
+			ok:          true,
+			replayed:    []uint64{},
+		},
+		{
+			name:        "a few behind",
+			published:   10,
+			lastEventID: func(ps *pubSub) string { return ps.formatID(5) },
+			ok:          true,
+			replayed:    idRange(6, 10),
+		},
+		{
+			name:        "behind more than a client queue",
+			published:   replaySize + 44,
+			lastEventID: func(ps *pubSub) string { return ps.formatID(100) },
+			ok:          true,
+			replayed:    idRange(101, replaySize+44),
+		},
+		{
+			name:        "oldest replayed event",
+			published:   replaySize + 44,
+			lastEventID: func(ps *pubSub) string { return ps.formatID(44) },
+			ok:          true,
+			replayed:    idRange(45, replaySize+44),
+		},
+		{
+			name:        "missed events evicted",
+			published:   replaySize + 44,
+			lastEventID: func(ps *pubSub) string { return ps.formatID(43) },
+			ok:          false,
+			replayed:    []uint64{},
+		},
+		{
+			name:        "previous run",
+			published:   10,
+			lastEventID: func(ps *pubSub) string { return "previous.5" },
+			ok:          false,
+			replayed:    []uint64{},
+		},
+		{
+			name:        "id from the future",
+			published:   10,
+			lastEventID: func(ps *pubSub) string { return ps.formatID(11) },
+			ok:          false,
+			replayed:    []uint64{},
+		},
+		{
+			name:        "malformed id",
+			published:   10,
+			lastEventID: func(ps *pubSub) string { return "5" },
+			ok:          false,
+			replayed:    []uint64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newPubSub()
+			for range tt.published {
+				ps.notify(connectedEvent())
+			}
+
+			sub, ok := ps.subscribe(tt.lastEventID(ps), clientInfo{})
+			if ok != tt.ok {
+				t.Fatalf("subscribe ok = %v, want %v", ok, tt.ok)
+			}
+
+			queue, overflowed := sub.drain()
+			if overflowed {
+				t.Errorf("replay overflowed the queue")
+			}
+			if got := envelopeIDs(queue); !slices.Equal(got, tt.replayed) {
+				t.Errorf("replayed %v, want %v", got, tt.replayed)
+			}
+		})
+	}
+}
+
+func TestSubscriberOverflow(t *testing.T) {
+	tests := []struct {
+		name       string
+		replay     int
+		pushed     int
+		overflowed bool
+		first      uint64
+		length     int
+	}{
+		{name: "fits", pushed: clientQueueSize, first: 1, length: clientQueueSize},
+		{name: "one too many", pushed: clientQueueSize + 1, overflowed: true, first: 2, length: clientQueueSize},
+		{name: "replay then a full queue", replay: 100, pushed: clientQueueSize, first: 1, length: 100 + clientQueueSize},
+		{name: "replay then one too many", replay: 100, pushed: clientQueueSize + 1, overflowed: true, first: 2, length: 100 + clientQueueSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replay := []envelope{}
+			for id := range uint64(tt.replay) {
+				replay = append(replay, envelope{id: id + 1})
+			}
+
+			sub := newSubscriber(clientInfo{}, replay)
+			for id := range uint64(tt.pushed) {
+				sub.push(envelope{id: uint64(tt.replay) + id + 1})
+			}
+
+			queue, overflowed := sub.drain()
+			if overflowed != tt.overflowed {
+				t.Errorf("overflowed = %v, want %v", overflowed, tt.overflowed)
+			}
+			if len(queue) != tt.length {
+				t.Fatalf("queued %d events, want %d", len(queue), tt.length)
+			}
+			if queue[0].id != tt.first {
+				t.Errorf("oldest queued event is %d, want %d", queue[0].id, tt.first)
+			}
+
+			// the queue is back to its regular size once the replay is sent
+			for id := range uint64(clientQueueSize + 1) {
+				sub.push(envelope{id: id})
+			}
+			if _, overflowed := sub.drain(); !overflowed {
+				t.Errorf("a drained queue holds more than %d events", clientQueueSize)
+			}
+		})
+	}
+}
@@ -2,20 +2,36 @@ package server
 
 import (
 	"bytes"
-	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"io"
 	"maps"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/sgq995/nova/internal/diagnostic"
+	"github.com/sgq995/nova/internal/logger"
+	"github.com/sgq995/nova/internal/module"
 )
 
+// cancelGracePeriod is how long a route module may keep running after its
+// request was cancelled.
+const cancelGracePeriod = 5 * time.Second
+
+type tlsState struct {
+	Version            uint16 `json:"version"`
+	CipherSuite        uint16 `json:"cipherSuite"`
+	ServerName         string `json:"serverName"`
+	NegotiatedProtocol string `json:"negotiatedProtocol"`
+	HandshakeComplete  bool   `json:"handshakeComplete"`
+}
+
 type request struct {
 	Method string `json:"method"`
 
@@ -29,17 +45,26 @@ type request struct {
 
 	ContentLength int64 `json:"contentLength"`
 
+	TransferEncoding []string `json:"transferEncoding"`
+
 	Host string `json:"host"`
 
+	// Trailer lists the declared trailer keys, values follow the body
+	Trailer []string `json:"trailer"`
+
 	RemoteAddr string `json:"remoteAddr"`
 
 	RequestURI string `json:"requestUri"`
 
+	TLS *tlsState `json:"tls"`
+
 	Pattern string `json:"pattern"`
+
+	PathValues map[string]string `json:"pathValues"`
 }
 
 func transformRequest(r *http.Request) *request {
-	return &request{
+	req := &request{
 		Method: r.Method,
 
 		RawURL: r.URL.String(),
@@ -52,168 +77,386 @@ func transformRequest(r *http.Request) *request {
 
 		ContentLength: r.ContentLength,
 
+		TransferEncoding: r.TransferEncoding,
+
 		Host: r.Host,
 
+		Trailer: make([]string, 0, len(r.Trailer)),
+
 		RemoteAddr: r.RemoteAddr,
 
 		RequestURI: r.RequestURI,
 
 		Pattern: r.Pattern,
+
+		PathValues: pathValues(r),
+	}
+
+	for key := range r.Trailer {
+		req.Trailer = append(req.Trailer, key)
+	}
+
+	if r.TLS != nil {
+		req.TLS = newTLSState(r.TLS)
+	}
+
+	return req
+}
+
+func newTLSState(cs *tls.ConnectionState) *tlsState {
+	return &tlsState{
+		Version:            cs.Version,
+		CipherSuite:        cs.CipherSuite,
+		ServerName:         cs.ServerName,
+		NegotiatedProtocol: cs.NegotiatedProtocol,
+		HandshakeComplete:  cs.HandshakeComplete,
 	}
 }
 
+// pathValues collects the wildcards of the matched pattern.
+func pathValues(r *http.Request) map[string]string {
+	values := map[string]string{}
+
+	_, path, _ := strings.Cut(r.Pattern, " ")
+	if path == "" {
+		path = r.Pattern
+	}
+
+	for _, segment := range strings.Split(path, "/") {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.Trim(segment, "{}"), "...")
+		if name == "" || name == "$" {
+			continue
+		}
+		values[name] = r.PathValue(name)
+	}
+
+	return values
+}
+
 type responseWriter struct {
 	Headers    http.Header `json:"headers"`
 	StatusCode int         `json:"statusCode"`
 }
 
 type routeModule struct {
-	pagespath string
+	lookup func(pattern string) string
+	report reportFunc
+
+	mu      sync.Mutex
+	running map[*exec.Cmd]struct{}
 }
 
-func newRouteModule(pagespath string) *routeModule {
+func newRouteModule(lookup func(pattern string) string, report reportFunc) *routeModule {
 	return &routeModule{
-		pagespath: pagespath,
+		lookup:  lookup,
+		report:  report,
+		running: map[*exec.Cmd]struct{}{},
+	}
+}
+
+func (rm *routeModule) track(cmd *exec.Cmd) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.running[cmd] = struct{}{}
+}
+
+func (rm *routeModule) untrack(cmd *exec.Cmd) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	delete(rm.running, cmd)
+}
+
+// close kills the route modules still serving requests.
+func (rm *routeModule) close() {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	for cmd := range rm.running {
+		killProcessGroup(cmd)
 	}
 }
 
+// routeSource keys the diagnostics of source reported by the route module at
+// filename, so a route serving fine doesn't clear the errors of another.
+func routeSource(source string, filename string) string {
+	return source + ":" + module.Rel(filename)
+}
+
 func (rm *routeModule) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	upath := r.URL.Path
-	upath = strings.TrimPrefix(upath, "/api")
-	filename := filepath.Join(rm.pagespath, filepath.FromSlash(upath), "main.go")
+	filename := rm.lookup(r.Pattern)
+	if filename == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("go", "run", filename)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	setProcessGroup(cmd)
 
-	stdoutReader, stdoutWriter, err := os.Pipe()
+	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	cmd := exec.CommandContext(r.Context(), "go", "run", filename)
-	cmd.Stdout = stdoutWriter
-	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	stdin, err := cmd.StdinPipe()
+	err = cmd.Start()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	rm.track(cmd)
+	defer rm.untrack(cmd)
+
+	out := newFrameWriter(stdin)
+	done := make(chan struct{})
 
-	var wg sync.WaitGroup
-	ctx, cancel := context.WithCancel(r.Context())
+	go rm.sendRequest(out, r, done)
 
-	controller := http.NewResponseController(w)
+	logs := &moduleLog{prefix: r.Pattern}
+	responded, recovered := rm.receiveResponse(w, stdout, out, logs)
+	close(done)
+	stdin.Close()
+	logs.flush()
 
-	wg.Add(1)
+	exited := make(chan error, 1)
 	go func() {
-		defer wg.Done()
+		exited <- cmd.Wait()
+	}()
 
-		request := transformRequest(r)
-		encoder := json.NewEncoder(stdin)
-		if err := encoder.Encode(request); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	var runErr error
+	select {
+	case runErr = <-exited:
 
-		_, err := io.Copy(stdin, r.Body)
-		if err != nil {
-			return
+	case <-time.After(cancelGracePeriod):
+		killProcessGroup(cmd)
+		runErr = <-exited
+	}
+
+	if r.Context().Err() != nil {
+		return
+	}
+
+	if recovered == nil && runErr != nil {
+		recovered = parseCrash(stderr.Bytes())
+	}
+
+	info := &debugInfo{
+		Request: r,
+		Logs:    logs.buf.String(),
+		Output:  stderr.String(),
+	}
+
+	goSource := routeSource(diagnostic.SourceGo, filename)
+	runtimeSource := routeSource(diagnostic.SourceRuntime, filename)
+
+	switch {
+	case recovered != nil:
+		rm.report(goSource, nil)
+
+		info.Panic = recovered
+		info.Frames = parseStack(recovered.Stack, filename)
+		info.Diagnostics = panicDiagnostics(recovered, info.Frames)
+		rm.report(runtimeSource, info.Diagnostics)
+
+	case runErr != nil:
+		info.Diagnostics = diagnostic.ParseGo(cmd.Dir, stderr.Bytes())
+		if len(info.Diagnostics) == 0 {
+			info.Diagnostics = append(info.Diagnostics, diagnostic.Diagnostic{
+				Source:  diagnostic.SourceGo,
+				Message: runErr.Error(),
+			})
 		}
-	}()
+		rm.report(goSource, info.Diagnostics)
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	default:
+		rm.report(goSource, nil)
+		rm.report(runtimeSource, nil)
+		return
+	}
 
-		var header bytes.Buffer
-		var msg []byte
-		buf := make([]byte, 1024)
+	if responded {
+		// the client got part of a response, make sure it doesn't look complete
+		panic(http.ErrAbortHandler)
+	}
+	writeDebugPage(w, info)
+}
 
-		for {
-			n, err := stdoutReader.Read(buf)
-			if err != nil && !errors.Is(err, io.EOF) {
-				return
-			}
-			if n > 0 {
-				msg = buf[:n]
-				if msg[0] != '{' {
-					http.Error(w, "bad data: "+string(msg), http.StatusInternalServerError)
-					return
-				}
-				var count int
-				for _, b := range msg {
-					header.WriteByte(b)
-
-					if b == '{' {
-						count++
-					} else if b == '}' {
-						count--
-					} else if b == '\n' && count == 0 {
-						break
-					}
-				}
-
-				break
-			}
+// moduleLog prints the output of a route module line by line and keeps it
+// for the debug page.
+type moduleLog struct {
+	prefix  string
+	buf     bytes.Buffer
+	pending []byte
+}
+
+func (l *moduleLog) Write(b []byte) (int, error) {
+	l.buf.Write(b)
+	l.pending = append(l.pending, b...)
+	for {
+		i := bytes.IndexByte(l.pending, '\n')
+		if i < 0 {
+			break
+		}
+		logger.Infof("[%s] %s", l.prefix, l.pending[:i])
+		l.pending = l.pending[i+1:]
+	}
+	return len(b), nil
+}
+
+func (l *moduleLog) flush() {
+	if len(l.pending) > 0 {
+		logger.Infof("[%s] %s", l.prefix, l.pending)
+		l.pending = nil
+	}
+}
+
+// sendRequest streams the request head, body and trailers to the module,
+// followed by a cancel frame if the request context ends first.
+func (rm *routeModule) sendRequest(out *frameWriter, r *http.Request, done <-chan struct{}) {
+	go func() {
+		select {
+		case <-r.Context().Done():
+			out.write(frameCancel, nil)
 
-			time.Sleep(time.Millisecond)
+		case <-done:
 		}
+	}()
 
-		body := msg[header.Len():]
+	err := out.writeJSON(frameHead, transformRequest(r))
+	if err != nil {
+		return
+	}
+
+	err = out.copyFrames(frameData, r.Body)
+	if err != nil {
+		logger.Debugf("[server] request body: %+v", err)
+		return
+	}
 
-		var response responseWriter
-		decoder := json.NewDecoder(&header)
-		err := decoder.Decode(&response)
+	if len(r.Trailer) > 0 {
+		err = out.writeJSON(frameTrailer, r.Trailer)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+	}
 
-		maps.Copy(w.Header(), response.Headers)
+	out.write(frameEnd, nil)
+}
 
-		if response.StatusCode != 0 {
-			w.WriteHeader(response.StatusCode)
-		}
+// receiveResponse replays the frames of the module on w until the response
+// ends. It reports whether anything was sent to the client and the panic that
+// ended the handler, if any.
+func (rm *routeModule) receiveResponse(w http.ResponseWriter, stdout io.Reader, out *frameWriter, logs *moduleLog) (bool, *handlerPanic) {
+	rc := http.NewResponseController(w)
+	responded := false
 
-		if len(body) > 0 {
-			w.Write(body)
+	for {
+		f, err := readFrame(stdout)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				logger.Errorf("[server] %+v", err)
+			}
+			return responded, nil
 		}
 
-		for {
-			n, err := stdoutReader.Read(buf)
-			if err != nil && !errors.Is(err, io.EOF) {
-				return
+		switch f.typ {
+		case frameHead:
+			var response responseWriter
+			err := json.Unmarshal(f.payload, &response)
+			if err != nil {
+				logger.Errorf("[server] %+v", err)
+				return responded, nil
 			}
-			if n > 0 {
-				w.Write(buf[:n])
-				controller.Flush()
+			responded = true
+			maps.Copy(w.Header(), response.Headers)
+			w.WriteHeader(response.StatusCode)
+
+		case frameData:
+			w.Write(f.payload)
+
+		case frameFlush:
+			rc.Flush()
+
+		case frameTrailer:
+			var trailer http.Header
+			err := json.Unmarshal(f.payload, &trailer)
+			if err != nil {
+				logger.Errorf("[server] %+v", err)
+				continue
+			}
+			for key, values := range trailer {
+				w.Header()[http.TrailerPrefix+key] = values
 			}
 
-			select {
-			case <-ctx.Done():
-				return
+		case frameLog:
+			logs.Write(f.payload)
 
-			default:
-				time.Sleep(time.Millisecond)
+		case frameError:
+			var recovered handlerPanic
+			err := json.Unmarshal(f.payload, &recovered)
+			if err != nil {
+				logger.Errorf("[server] %+v", err)
+				return responded, nil
 			}
+			return responded, &recovered
+
+		case frameEnd:
+			return responded, nil
+
+		case frameHijack:
+			conn, brw, err := rc.Hijack()
+			if err != nil {
+				logger.Errorf("[server] %+v", err)
+				out.write(frameConnClose, nil)
+				return responded, nil
+			}
+			buffered, _ := brw.Reader.Peek(brw.Reader.Buffered())
+			rm.proxyConn(conn, bytes.NewReader(buffered), stdout, out, logs)
+			return true, nil
 		}
-	}()
+	}
+}
+
+// proxyConn pipes a hijacked client connection through the module frames.
+func (rm *routeModule) proxyConn(conn net.Conn, buffered io.Reader, stdout io.Reader, out *frameWriter, logs *moduleLog) {
+	defer conn.Close()
 
-	wg.Add(1)
 	go func() {
-		defer wg.Done()
+		err := out.copyFrames(frameConnData, io.MultiReader(buffered, conn))
+		if err != nil {
+			logger.Debugf("[server] hijacked connection: %+v", err)
+		}
+		out.write(frameConnClose, nil)
+	}()
 
-		err := cmd.Start()
+	for {
+		f, err := readFrame(stdout)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		cmd.Wait()
 
-		stdoutWriter.Close()
-		stdoutReader.Close()
+		switch f.typ {
+		case frameConnData:
+			_, err := conn.Write(f.payload)
+			if err != nil {
+				return
+			}
 
-		cancel()
-	}()
+		case frameLog:
+			logs.Write(f.payload)
 
-	wg.Wait()
+		case frameConnClose:
+			return
+		}
+	}
 }
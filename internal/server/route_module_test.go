@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/sgq995/nova/internal/diagnostic"
+)
+
+// okModule answers every request with an empty 204 through the frames.
+const okModule = `package main
+
+import "os"
+
+func frame(typ byte, payload string) {
+	n := len(payload)
+	os.Stdout.Write(append([]byte{typ, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}, payload...))
+}
+
+func main() {
+	frame(1, ` + "`" + `{"headers":{},"statusCode":204}` + "`" + `)
+	frame(5, "")
+}
+`
+
+const brokenModule = `package main
+
+func main() {
+	var n int = "not a number"
+	_ = n
+}
+`
+
+func TestRouteModuleDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	modules := map[string]string{}
+	for name, source := range map[string]string{"ok": okModule, "broken": brokenModule} {
+		filename := filepath.Join(dir, name, "main.go")
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modules["GET /"+name] = filename
+	}
+
+	var mu sync.Mutex
+	reported := map[string][]diagnostic.Diagnostic{}
+	report := func(source string, diagnostics []diagnostic.Diagnostic) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported[source] = diagnostics
+	}
+
+	rm := newRouteModule(func(pattern string) string { return modules[pattern] }, report)
+	defer rm.close()
+
+	serve := func(pattern string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Pattern = pattern
+		w := httptest.NewRecorder()
+		rm.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := serve("GET /broken"); code != http.StatusInternalServerError {
+		t.Errorf("broken route answered %d, want %d", code, http.StatusInternalServerError)
+	}
+	if code := serve("GET /ok"); code != http.StatusNoContent {
+		t.Errorf("ok route answered %d, want %d", code, http.StatusNoContent)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	broken := routeSource(diagnostic.SourceGo, modules["GET /broken"])
+	if len(reported[broken]) == 0 {
+		t.Errorf("the ok route cleared the compile error of the broken one, reported %v", reported)
+	}
+	ok := routeSource(diagnostic.SourceGo, modules["GET /ok"])
+	if diagnostics, exists := reported[ok]; !exists || len(diagnostics) > 0 {
+		t.Errorf("%s = %v, want it cleared", ok, diagnostics)
+	}
+}
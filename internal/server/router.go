@@ -2,37 +2,87 @@ package server
 
 import (
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/sgq995/nova/internal/logger"
 )
 
 type memRouter struct {
-	mu     sync.Mutex
-	routes map[string]struct{}
+	mu sync.Mutex
+	// routes maps a pattern to the main.go of its route module, which is
+	// empty when routes are served by the dev application
+	routes map[string]string
 }
 
 func newMemRouter() *memRouter {
 	return &memRouter{
-		routes: make(map[string]struct{}),
+		routes: make(map[string]string),
 	}
 }
 
-func (mr *memRouter) add(pattern string) {
+func (mr *memRouter) add(pattern string, module string) bool {
 	mr.mu.Lock()
 	defer mr.mu.Unlock()
 	logger.Debugf("[server] add %s\n", pattern)
-	mr.routes[pattern] = struct{}{}
+	_, exists := mr.routes[pattern]
+	mr.routes[pattern] = module
+	return exists
 }
 
-func (mr *memRouter) remove(pattern string) {
+// remove unregisters pattern and returns the route module that served it.
+func (mr *memRouter) remove(pattern string) string {
 	mr.mu.Lock()
 	defer mr.mu.Unlock()
 	logger.Debugf("[server] remove %s\n", pattern)
+	module := mr.routes[pattern]
 	delete(mr.routes, pattern)
+	return module
+}
+
+// serves reports whether a pattern is still served by module.
+func (mr *memRouter) serves(module string) bool {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	for _, m := range mr.routes {
+		if m == module {
+			return true
+		}
+	}
+	return false
+}
+
+func (mr *memRouter) module(pattern string) string {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	return mr.routes[pattern]
+}
+
+// routeInfo describes a registered route for the dashboard.
+type routeInfo struct {
+	Pattern string `json:"pattern"`
+	Module  string `json:"module,omitempty"`
+}
+
+func (mr *memRouter) list() []routeInfo {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	routes := make([]routeInfo, 0, len(mr.routes))
+	for pattern, module := range mr.routes {
+		routes = append(routes, routeInfo{Pattern: pattern, Module: module})
+	}
+	slices.SortFunc(routes, func(a, b routeInfo) int {
+		return strings.Compare(a.Pattern, b.Pattern)
+	})
+	return routes
 }
 
 func (mr *memRouter) newServeMux(handler http.Handler) *http.ServeMux {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
 	mux := http.NewServeMux()
 	for pattern := range mr.routes {
 		logger.Debugf("[server] handle %s\n", pattern)
@@ -1,12 +1,20 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	_ "embed"
+	"errors"
+	"net"
 	"net/http"
 	"strconv"
 
+	"github.com/sgq995/nova/internal/certs"
 	"github.com/sgq995/nova/internal/config"
+	"github.com/sgq995/nova/internal/diagnostic"
+	"github.com/sgq995/nova/internal/logger"
 	"github.com/sgq995/nova/internal/module"
+	"github.com/sgq995/nova/internal/watcher"
 )
 
 //go:embed hmr.js
@@ -17,40 +25,243 @@ type Server struct {
 
 	http *http.Server
 
-	hmr *hotModuleReplacer
+	listener net.Listener
+
+	hmr     *hotModuleReplacer
+	app     *devApp
+	modules *routeModule
 }
 
-func New(c *config.Config) *Server {
+// New sets the dev server up, it fails when server.proxy is invalid.
+func New(c *config.Config) (*Server, error) {
 	mux := http.NewServeMux()
 
-	hmr := newHotModuleReplacer(module.Join(c.Codegen.OutDir, "pages"))
+	var hmr *hotModuleReplacer
+	// route processes and proxies report while serving, the dev application
+	// when it is built
+	report := func(source string, diagnostics []diagnostic.Diagnostic) {
+		hmr.dashboard.reportHealth(source, diagnostics)
+		hmr.Send(DiagnosticMessage(source, diagnostics))
+	}
+	reportBuild := func(source string, diagnostics []diagnostic.Diagnostic) {
+		hmr.dashboard.build(source, diagnostics)
+		hmr.Send(DiagnosticMessage(source, diagnostics))
+	}
+
+	router := newMemRouter()
+
+	var app *devApp
+	var modules *routeModule
+	var handler http.Handler
+	if c.Server.Runtime == config.RuntimeApp {
+		app = newDevApp(module.Join(c.Codegen.OutDir, "dev", "main.go"), reportBuild)
+		handler = app
+	} else {
+		modules = newRouteModule(router.module, report)
+		handler = modules
+	}
+
+	hmr = newHotModuleReplacer(router, handler)
 	hmr.Send(UpdateFileMessage("@nova/hmr.js", hmrJS))
 
 	nodeModules := module.Join("node_modules", ".nova")
 	mux.Handle("/@node_modules/", http.StripPrefix("/@node_modules", http.FileServer(http.Dir(nodeModules))))
-	mux.HandleFunc("/@nova/hmr", hmr.serveNovaHMR)
-	mux.Handle("/", hmr)
+	mux.HandleFunc("/@nova/hmr", sameOrigin(c, hmr.serveNovaHMR))
+	mux.HandleFunc("POST /@nova/hmr", sameOrigin(c, hmr.receiveNovaHMR))
+	mux.HandleFunc("/@nova/hmr/ws", sameOrigin(c, hmr.serveNovaHMRWebSocket))
+	mux.HandleFunc("GET /@nova/{$}", sameOrigin(c, hmr.dashboard.serveDashboard))
+	mux.HandleFunc("GET /@nova/api/state", sameOrigin(c, hmr.dashboard.serveState))
+	err := mountProxies(mux, c.Server.Proxy, report)
+	if err != nil {
+		hmr.close()
+		if app != nil {
+			app.close()
+		}
+		return nil, err
+	}
+	mountPlugins(mux)
+	mux.Handle("/", injectHMR(hmr))
 
 	httpServer := http.Server{
 		Addr:    c.Server.Host + ":" + strconv.Itoa(int(c.Server.Port)),
-		Handler: mux,
+		Handler: hmr.dashboard.logRequests(pluginMiddleware(mux)),
 	}
 
 	return &Server{
-		config: c,
-		http:   &httpServer,
-		hmr:    hmr,
-	}
+		config:  c,
+		http:    &httpServer,
+		hmr:     hmr,
+		app:     app,
+		modules: modules,
+	}, nil
 }
 
 func (s *Server) Send(msg *Message) {
 	s.hmr.Send(msg)
 }
 
-func (s *Server) ListenAndServe() error {
-	return s.http.ListenAndServe()
+// Built records the result of an esbuild or codegen run on the dashboard,
+// diagnostics reach browsers through DiagnosticMessage.
+func (s *Server) Built(source string, diagnostics []diagnostic.Diagnostic) {
+	s.hmr.dashboard.build(source, diagnostics)
+}
+
+// ReloadApp rebuilds the dev application in the background, the previous
+// process keeps serving until the new one is ready. It is a no-op unless the
+// server runs with the "app" runtime.
+func (s *Server) ReloadApp() {
+	if s.app != nil {
+		s.app.reload()
+	}
+}
+
+// WatcherStatus reports the state of a file watcher of the project, a failing
+// watcher is shown in the overlay until it runs again.
+func (s *Server) WatcherStatus(status watcher.Status) {
+	diagnostics := []diagnostic.Diagnostic{}
+	if !status.Running {
+		diagnostics = append(diagnostics, diagnostic.Diagnostic{
+			Source:  diagnostic.SourceWatcher,
+			File:    status.Dir,
+			Message: "changes are not noticed, restarting the watcher: " + status.Error,
+		})
+	}
+
+	s.hmr.dashboard.watcher(status)
+	s.hmr.Send(DiagnosticMessage(diagnostic.SourceWatcher+" "+status.Dir, diagnostics))
+}
+
+// portAttempts is how many ports are tried, starting at server.port, unless
+// server.strictPort is set.
+const portAttempts = 10
+
+// Listen binds the dev server, so that errors like a busy port surface right
+// away instead of from the serving goroutine.
+func (s *Server) Listen() error {
+	host := s.config.Server.Host
+	port := int(s.config.Server.Port)
+
+	attempts := portAttempts
+	if s.config.Server.StrictPort {
+		attempts = 1
+	}
+
+	var ln net.Listener
+	var err error
+	for i := 0; i < attempts; i++ {
+		ln, err = net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port+i)))
+		if err == nil {
+			if i > 0 {
+				logger.Warnf("port %d is in use, using %d instead", port, port+i)
+			}
+			break
+		}
+		if !isAddrInUse(err) {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if s.config.Server.HTTPS {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		s.http.TLSConfig = tlsConfig
+	}
+
+	s.listener = ln
+	s.http.Addr = ln.Addr().String()
+	return nil
+}
+
+// tlsConfig issues certificates with the local authority, creating it on
+// first use.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	dir, err := certs.Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	ca, created, err := certs.LoadAuthority(dir)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		logger.Infof("%s", certs.TrustInstructions(ca.CertPath()))
+	} else {
+		logger.Debugf("[server] using the certificate authority %s", ca.CertPath())
+	}
+
+	return ca.TLSConfig(s.config.Server.Host, func(host string) bool {
+		return certificateHost(s.config, host)
+	}), nil
+}
+
+// URL is where the dev server can be reached once Listen returns.
+func (s *Server) URL() string {
+	scheme := "http"
+	if s.config.Server.HTTPS {
+		scheme = "https"
+	}
+
+	host := s.config.Server.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	port := strconv.Itoa(int(s.config.Server.Port))
+	if s.listener != nil {
+		_, port, _ = net.SplitHostPort(s.listener.Addr().String())
+	}
+
+	return scheme + "://" + net.JoinHostPort(host, port) + "/"
+}
+
+// Serve serves plain HTTP, or HTTPS and HTTP/2 when server.https is set, on
+// the listener bound by Listen. It returns nil once the server is shut down.
+func (s *Server) Serve() error {
+	var err error
+	if s.config.Server.HTTPS {
+		err = s.http.ServeTLS(s.listener, "", "")
+	} else {
+		err = s.http.Serve(s.listener)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests.
+// When ctx ends first, the route modules still running are killed and the
+// remaining connections closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.hmr.close()
+
+	err := s.http.Shutdown(ctx)
+	s.closeChildren()
+	if err != nil {
+		s.http.Close()
+	}
+	return err
 }
 
 func (s *Server) Close() error {
+	s.hmr.close()
+	s.closeChildren()
 	return s.http.Close()
 }
+
+func (s *Server) closeChildren() {
+	if s.modules != nil {
+		s.modules.close()
+	}
+	if s.app != nil {
+		s.app.close()
+	}
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sgq995/nova/internal/config"
+	"github.com/sgq995/nova/internal/logger"
+)
+
+const heartbeatInterval = 15 * time.Second
+
+// sameOrigin guards the HMR endpoints and the dashboard against other
+// websites open in the browser: a request with an Origin must come from a
+// page of the dev server reached by a known host, see knownHost, or from one
+// of server.allowedHosts. Requests without one come from tools or from pages
+// of the dev server, and must name a known host too: a site rebinding its
+// name to this machine reads it as same-origin, without sending an Origin.
+func sameOrigin(c *config.Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := (&url.URL{Host: r.Host}).Hostname()
+		if !knownHost(c, host) {
+			logger.Warnf("[hmr] rejected a request for host %s", r.Host)
+			http.Error(w, "nova: host not allowed", http.StatusForbidden)
+			return
+		}
+
+		origin := r.Header.Get("Origin")
+		if origin != "" && !allowedOrigin(c, origin, r.Host) {
+			logger.Warnf("[hmr] rejected a request from %s", origin)
+			http.Error(w, "nova: origin not allowed", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func allowedOrigin(c *config.Config, origin string, host string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	// a tunnel may serve the pages on a port of its own
+	if slices.Contains(c.Server.AllowedHosts, u.Hostname()) {
+		return true
+	}
+
+	// another site may reach this server through a name resolving to it
+	return strings.EqualFold(u.Host, host) && knownHost(c, u.Hostname())
+}
+
+// transport delivers events to a single HMR client.
+type transport interface {
+	send(id string, e *Event) error
+	ping() error
+	flush() error
+}
+
+type sseTransport struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func writeEvent(w io.Writer, id string, e *Event) error {
+	data, err := e.encode()
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		_, err = fmt.Fprintf(w, "id: %s\n", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind, data)
+	return err
+}
+
+func (t *sseTransport) send(id string, e *Event) error {
+	return writeEvent(t.w, id, e)
+}
+
+func (t *sseTransport) ping() error {
+	_, err := fmt.Fprint(t.w, ": ping\n\n")
+	return err
+}
+
+func (t *sseTransport) flush() error {
+	return t.rc.Flush()
+}
+
+type websocketTransport struct {
+	ws *websocketConn
+}
+
+func (t *websocketTransport) send(id string, e *Event) error {
+	data, err := json.Marshal(struct {
+		ID string `json:"id,omitempty"`
+		*Event
+	}{id, e})
+	if err != nil {
+		return err
+	}
+	return t.ws.writeText(data)
+}
+
+func (t *websocketTransport) ping() error {
+	return t.ws.ping()
+}
+
+func (t *websocketTransport) flush() error {
+	return nil
+}
+
+func newClientInfo(r *http.Request, transport string) clientInfo {
+	return clientInfo{
+		Transport:  transport,
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+		Dashboard:  r.URL.Query().Has("dashboard"),
+	}
+}
+
+// stream subscribes a client and forwards events to it until ctx is done or
+// the transport fails.
+func (hmr *hotModuleReplacer) stream(ctx context.Context, lastEventID string, info clientInfo, t transport) {
+	sub, ok := hmr.ps.subscribe(lastEventID, info)
+	hmr.dashboard.changed()
+	defer hmr.dashboard.changed()
+	defer hmr.ps.unsubscribe(sub)
+
+	pending := []*Event{connectedEvent()}
+	hmr.mu.Lock()
+	for source, diagnostics := range hmr.diagnostics {
+		pending = append(pending, diagnosticEvent(source, diagnostics))
+	}
+	hmr.mu.Unlock()
+	if !ok {
+		pending = append(pending, reloadEvent("missed events"))
+	}
+
+	for _, e := range pending {
+		if err := t.send("", e); err != nil {
+			return
+		}
+	}
+	if err := t.flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hmr.done:
+			return
+
+		case <-heartbeat.C:
+			if err := t.ping(); err != nil {
+				return
+			}
+
+		case <-sub.ready:
+			envs, overflowed := sub.drain()
+			if overflowed {
+				if err := t.send("", reloadEvent("missed events")); err != nil {
+					return
+				}
+			}
+			for _, env := range envs {
+				id := ""
+				if env.id != 0 {
+					id = hmr.ps.formatID(env.id)
+				}
+				if err := t.send(id, env.event); err != nil {
+					return
+				}
+			}
+		}
+
+		if err := t.flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (hmr *hotModuleReplacer) serveNovaHMR(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	if _, err := fmt.Fprint(w, "retry: 1000\n\n"); err != nil {
+		return
+	}
+
+	// the query parameter lets clients resume a stream started by another
+	// transport, the browser only sends the header on its own reconnects
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	t := &sseTransport{w: w, rc: http.NewResponseController(w)}
+	hmr.stream(r.Context(), lastEventID, newClientInfo(r, "sse"), t)
+}
+
+func (hmr *hotModuleReplacer) serveNovaHMRWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		logger.Debugf("[hmr] %+v", err)
+		return
+	}
+	defer ws.close(closeNormal, "")
+
+	// a hijacked request context outlives the connection, the read loop is
+	// what notices the client going away
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		defer cancel()
+		for {
+			opcode, message, err := ws.readMessage()
+			if err != nil {
+				return
+			}
+			if opcode == opText {
+				hmr.receive(message)
+			}
+		}
+	}()
+
+	hmr.stream(ctx, r.URL.Query().Get("lastEventId"), newClientInfo(r, "websocket"), &websocketTransport{ws: ws})
+}
+
+// receiveNovaHMR accepts client messages from clients that can't use the
+// WebSocket channel.
+func (hmr *hotModuleReplacer) receiveNovaHMR(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(io.LimitReader(r.Body, websocketMaxMessage))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hmr.receive(b)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (hmr *hotModuleReplacer) receive(data []byte) {
+	var msg ClientMessage
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		logger.Warnf("[hmr] invalid client message: %+v", err)
+		return
+	}
+
+	switch msg.Kind {
+	case AckMessage:
+		logger.Debugf("[hmr] ack %s", msg.ID)
+
+	case ErrorMessage:
+		logger.Errorf("[browser] %s (%s)", msg.Message, msg.URL)
+		if msg.Stack != "" {
+			logger.Errorf("[browser] %s", msg.Stack)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal RFC 6455 implementation, enough for the HMR channel: text and
+// control frames, fragmented messages and the closing handshake.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xA
+)
+
+const (
+	closeNormal          = 1000
+	closeProtocolError   = 1002
+	closeMessageTooLarge = 1009
+)
+
+const websocketMaxMessage = 1 << 20
+
+var errWebSocketClosed = errors.New("websocket: closed")
+
+type websocketConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+func headerContainsToken(h http.Header, name string, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sendableCloseCode reports whether code may be echoed in a close frame,
+// 1005, 1006 and 1015 only exist for APIs and unassigned codes are invalid.
+func sendableCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+
+	default:
+		return code >= 3000 && code <= 4999
+	}
+}
+
+func websocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// upgradeWebSocket performs the opening handshake and takes over the
+// connection, on failure a response has already been written.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*websocketConn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket: upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: not a websocket handshake")
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "websocket: unsupported version", http.StatusBadRequest)
+		return nil, errors.New("websocket: unsupported version")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "websocket: missing key", http.StatusBadRequest)
+		return nil, errors.New("websocket: missing key")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+
+	_, err = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// the handshake may have set a deadline on the hijacked connection
+	conn.SetDeadline(time.Time{})
+
+	return &websocketConn{conn: conn, rw: rw}, nil
+}
+
+func (ws *websocketConn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(ws.rw, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := head[0]&0x80 != 0
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, ws.fail(closeProtocolError, "reserved bits set")
+	}
+
+	if !masked {
+		return false, 0, nil, ws.fail(closeProtocolError, "client frames must be masked")
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.rw, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.rw, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if opcode >= opClose && (length > 125 || !fin) {
+		return false, 0, nil, ws.fail(closeProtocolError, "invalid control frame")
+	}
+
+	if length > websocketMaxMessage {
+		return false, 0, nil, ws.fail(closeMessageTooLarge, "message too large")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.rw, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.rw, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// readMessage returns the next data message, answering control frames on
+// the way. It returns errWebSocketClosed once the peer closes.
+func (ws *websocketConn) readMessage() (byte, []byte, error) {
+	var opcode byte
+	var message []byte
+
+	for {
+		fin, op, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := ws.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+
+		case opPong:
+			continue
+
+		case opClose:
+			code := closeNormal
+			if len(payload) >= 2 && sendableCloseCode(int(binary.BigEndian.Uint16(payload))) {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			ws.close(code, "")
+			return 0, nil, errWebSocketClosed
+
+		case opContinuation:
+			if message == nil {
+				return 0, nil, ws.fail(closeProtocolError, "unexpected continuation frame")
+			}
+
+		case opText, opBinary:
+			if message != nil {
+				return 0, nil, ws.fail(closeProtocolError, "expected continuation frame")
+			}
+			opcode = op
+			message = []byte{}
+
+		default:
+			return 0, nil, ws.fail(closeProtocolError, "unknown opcode")
+		}
+
+		if len(message)+len(payload) > websocketMaxMessage {
+			return 0, nil, ws.fail(closeMessageTooLarge, "message too large")
+		}
+		message = append(message, payload...)
+
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (ws *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+
+	if ws.closed {
+		return errWebSocketClosed
+	}
+
+	return ws.writeFrameLocked(opcode, payload)
+}
+
+func (ws *websocketConn) writeFrameLocked(opcode byte, payload []byte) error {
+	head := make([]byte, 0, 10)
+	head = append(head, 0x80|opcode)
+
+	length := len(payload)
+	switch {
+	case length <= 125:
+		head = append(head, byte(length))
+
+	case length <= 0xFFFF:
+		head = append(head, 126)
+		head = binary.BigEndian.AppendUint16(head, uint16(length))
+
+	default:
+		head = append(head, 127)
+		head = binary.BigEndian.AppendUint64(head, uint64(length))
+	}
+
+	if _, err := ws.rw.Write(head); err != nil {
+		return err
+	}
+	if _, err := ws.rw.Write(payload); err != nil {
+		return err
+	}
+	return ws.rw.Flush()
+}
+
+func (ws *websocketConn) writeText(b []byte) error {
+	return ws.writeFrame(opText, b)
+}
+
+func (ws *websocketConn) ping() error {
+	return ws.writeFrame(opPing, nil)
+}
+
+// close sends a close frame, if none was sent yet, and closes the connection.
+func (ws *websocketConn) close(code int, reason string) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+
+	if ws.closed {
+		return nil
+	}
+	ws.closed = true
+
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	ws.writeFrameLocked(opClose, payload)
+
+	return ws.conn.Close()
+}
+
+func (ws *websocketConn) fail(code int, reason string) error {
+	ws.close(code, reason)
+	return fmt.Errorf("websocket: %s", reason)
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// errAny stands for whatever error in test tables.
+var errAny = errors.New("any error")
+
+// clientFrame encodes a masked frame, as browsers send them.
+func clientFrame(fin bool, opcode byte, payload []byte) []byte {
+	return encodeFrame(fin, opcode, payload, []byte{0x12, 0x34, 0x56, 0x78})
+}
+
+// serverFrame encodes an unmasked frame, as the dev server sends them.
+func serverFrame(opcode byte, payload []byte) []byte {
+	return encodeFrame(true, opcode, payload, nil)
+}
+
+func encodeFrame(fin bool, opcode byte, payload []byte, mask []byte) []byte {
+	b := []byte{opcode}
+	if fin {
+		b[0] |= 0x80
+	}
+
+	maskBit := byte(0)
+	if mask != nil {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		b = append(b, maskBit|byte(len(payload)))
+
+	case len(payload) <= 0xFFFF:
+		b = append(b, maskBit|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+
+	default:
+		b = append(b, maskBit|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(len(payload)))
+	}
+
+	if mask == nil {
+		return append(b, payload...)
+	}
+	b = append(b, mask...)
+	for i, c := range payload {
+		b = append(b, c^mask[i%4])
+	}
+	return b
+}
+
+func closePayload(code int) []byte {
+	return binary.BigEndian.AppendUint16(nil, uint16(code))
+}
+
+func TestWebSocketReadMessage(t *testing.T) {
+	long := bytes.Repeat([]byte("a"), 200)
+
+	tests := []struct {
+		name    string
+		input   [][]byte
+		opcode  byte
+		message string
+		err     error // errAny for any error
+		reply   [][]byte
+	}{
+		{
+			name:    "text",
+			input:   [][]byte{clientFrame(true, opText, []byte("hello"))},
+			opcode:  opText,
+			message: "hello",
+		},
+		{
+			name:    "16-bit length",
+			input:   [][]byte{clientFrame(true, opBinary, long)},
+			opcode:  opBinary,
+			message: string(long),
+		},
+		{
+			name: "fragmented",
+			input: [][]byte{
+				clientFrame(false, opText, []byte("hel")),
+				clientFrame(false, opContinuation, []byte("l")),
+				clientFrame(true, opContinuation, []byte("o")),
+			},
+			opcode:  opText,
+			message: "hello",
+		},
+		{
+			name: "ping between fragments",
+			input: [][]byte{
+				clientFrame(false, opText, []byte("hel")),
+				clientFrame(true, opPing, []byte("ping")),
+				clientFrame(true, opContinuation, []byte("lo")),
+			},
+			opcode:  opText,
+			message: "hello",
+			reply:   [][]byte{serverFrame(opPong, []byte("ping"))},
+		},
+		{
+			name: "pong ignored",
+			input: [][]byte{
+				clientFrame(true, opPong, nil),
+				clientFrame(true, opText, []byte("hello")),
+			},
+			opcode:  opText,
+			message: "hello",
+		},
+		{
+			name:  "close echoes the code",
+			input: [][]byte{clientFrame(true, opClose, append(closePayload(1001), "bye"...))},
+			err:   errWebSocketClosed,
+			reply: [][]byte{serverFrame(opClose, closePayload(1001))},
+		},
+		{
+			name:  "close without code",
+			input: [][]byte{clientFrame(true, opClose, nil)},
+			err:   errWebSocketClosed,
+			reply: [][]byte{serverFrame(opClose, closePayload(closeNormal))},
+		},
+		{
+			name:  "close with a reserved code",
+			input: [][]byte{clientFrame(true, opClose, closePayload(1005))},
+			err:   errWebSocketClosed,
+			reply: [][]byte{serverFrame(opClose, closePayload(closeNormal))},
+		},
+		{
+			name:  "close with an unassigned code",
+			input: [][]byte{clientFrame(true, opClose, closePayload(2000))},
+			err:   errWebSocketClosed,
+			reply: [][]byte{serverFrame(opClose, closePayload(closeNormal))},
+		},
+		{
+			name:  "unmasked",
+			input: [][]byte{serverFrame(opText, []byte("hello"))},
+			err:   errAny,
+			reply: [][]byte{serverFrame(opClose, append(closePayload(closeProtocolError), "client frames must be masked"...))},
+		},
+		{
+			name:  "reserved bits",
+			input: [][]byte{append([]byte{0x80 | 0x40 | opText}, clientFrame(true, opText, nil)[1:]...)},
+			err:   errAny,
+			reply: [][]byte{serverFrame(opClose, append(closePayload(closeProtocolError), "reserved bits set"...))},
+		},
+		{
+			name:  "continuation first",
+			input: [][]byte{clientFrame(true, opContinuation, []byte("lo"))},
+			err:   errAny,
+			reply: [][]byte{serverFrame(opClose, append(closePayload(closeProtocolError), "unexpected continuation frame"...))},
+		},
+		{
+			name: "text inside a fragmented message",
+			input: [][]byte{
+				clientFrame(false, opText, []byte("hel")),
+				clientFrame(true, opText, []byte("lo")),
+			},
+			err:   errAny,
+			reply: [][]byte{serverFrame(opClose, append(closePayload(closeProtocolError), "expected continuation frame"...))},
+		},
+		{
+			name:  "fragmented control frame",
+			input: [][]byte{clientFrame(false, opPing, nil)},
+			err:   errAny,
+			reply: [][]byte{serverFrame(opClose, append(closePayload(closeProtocolError), "invalid control frame"...))},
+		},
+		{
+			name:  "control frame too long",
+			input: [][]byte{clientFrame(true, opPing, long)},
+			err:   errAny,
+			reply: [][]byte{serverFrame(opClose, append(closePayload(closeProtocolError), "invalid control frame"...))},
+		},
+		{
+			name:  "unknown opcode",
+			input: [][]byte{clientFrame(true, 0x3, nil)},
+			err:   errAny,
+			reply: [][]byte{serverFrame(opClose, append(closePayload(closeProtocolError), "unknown opcode"...))},
+		},
+		{
+			// only the header is sent, the length alone is rejected
+			name:  "frame too large",
+			input: [][]byte{binary.BigEndian.AppendUint64([]byte{0x80 | opText, 0x80 | 127}, websocketMaxMessage+1)},
+			err:   errAny,
+			reply: [][]byte{serverFrame(opClose, append(closePayload(closeMessageTooLarge), "message too large"...))},
+		},
+		{
+			name:  "truncated frame",
+			input: [][]byte{clientFrame(true, opText, []byte("hello"))[:4]},
+			err:   io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			ws := &websocketConn{
+				conn: server,
+				rw:   bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)),
+			}
+
+			go func() {
+				for _, frame := range tt.input {
+					if _, err := client.Write(frame); err != nil {
+						return
+					}
+				}
+				// a truncated frame ends with the connection
+				if tt.err == io.ErrUnexpectedEOF {
+					client.Close()
+				}
+			}()
+
+			replies := make(chan []byte)
+			go func() {
+				b, _ := io.ReadAll(client)
+				replies <- b
+			}()
+
+			opcode, message, err := ws.readMessage()
+			server.Close()
+			reply := <-replies
+
+			switch {
+			case tt.err == nil && err != nil:
+				t.Fatalf("readMessage failed: %v", err)
+
+			case tt.err == errAny && err == nil, tt.err != nil && tt.err != errAny && !errors.Is(err, tt.err):
+				t.Fatalf("readMessage error = %v, want %v", err, tt.err)
+			}
+
+			if opcode != tt.opcode || string(message) != tt.message {
+				t.Errorf("readMessage = %#x %q, want %#x %q", opcode, message, tt.opcode, tt.message)
+			}
+			if want := bytes.Join(tt.reply, nil); !bytes.Equal(reply, want) {
+				t.Errorf("replied %x, want %x", reply, want)
+			}
+		})
+	}
+}
+
+func TestSendableCloseCode(t *testing.T) {
+	tests := []struct {
+		code     int
+		sendable bool
+	}{
+		{999, false},
+		{1000, true},
+		{1003, true},
+		{1004, false},
+		{1005, false},
+		{1006, false},
+		{1007, true},
+		{1014, true},
+		{1015, false},
+		{2999, false},
+		{3000, true},
+		{4999, true},
+		{5000, false},
+	}
+
+	for _, tt := range tests {
+		if got := sendableCloseCode(tt.code); got != tt.sendable {
+			t.Errorf("sendableCloseCode(%d) = %v, want %v", tt.code, got, tt.sendable)
+		}
+	}
+}
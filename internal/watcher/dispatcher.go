@@ -1,38 +1,283 @@
 package watcher
 
 import (
-	"path/filepath"
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sgq995/nova/internal/logger"
-	"github.com/sgq995/nova/internal/must"
 )
 
-func dispatchEvent(callbacks CallbackMap, event Event, files []string) {
-	for matcher, cb := range callbacks {
+// mergeEvents folds next into the pending event of a file, ok is false when
+// they cancel out. A delete followed by a create is how editors save
+// atomically, so it is an update.
+func mergeEvents(prev, next Event) (Event, bool) {
+	switch {
+	case prev == CreateEvent && next == DeleteEvent:
+		return 0, false
+
+	case prev == CreateEvent:
+		return CreateEvent, true
+
+	case prev == DeleteEvent && next != DeleteEvent:
+		return UpdateEvent, true
+
+	default:
+		return next, true
+	}
+}
+
+// serialQueue runs jobs one at a time, in the order they were pushed.
+type serialQueue struct {
+	mu      sync.Mutex
+	jobs    []func()
+	running bool
+	stopped bool
+}
+
+func (q *serialQueue) push(job func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stopped {
+		return
+	}
+	q.jobs = append(q.jobs, job)
+	if !q.running {
+		q.running = true
+		go q.drain()
+	}
+}
+
+func (q *serialQueue) drain() {
+	for {
+		q.mu.Lock()
+		if len(q.jobs) == 0 || q.stopped {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		job := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		q.mu.Unlock()
+
+		job()
+	}
+}
+
+// stop drops the jobs not started yet, the running one finishes.
+func (q *serialQueue) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.stopped = true
+	q.jobs = nil
+}
+
+// dispatcher waits for changes to settle for window before calling back,
+// bursts of events on a file become its net change. Each matcher runs one
+// callback at a time.
+type dispatcher struct {
+	callbacks CallbackMap
+	window    time.Duration
+	queues    map[string]*serialQueue
+
+	mu      sync.Mutex
+	pending map[string]Event
+	order   []string
+	timer   *time.Timer
+	hashes  map[string][sha256.Size]byte
+	stopped bool
+}
+
+func newDispatcher(callbacks CallbackMap, window int) *dispatcher {
+	queues := map[string]*serialQueue{}
+	for matcher := range callbacks {
+		queues[matcher] = &serialQueue{}
+	}
+
+	return &dispatcher{
+		callbacks: callbacks,
+		window:    time.Duration(window) * time.Millisecond,
+		queues:    queues,
+		pending:   map[string]Event{},
+		hashes:    map[string][sha256.Size]byte{},
+	}
+}
+
+func (d *dispatcher) add(events *fileEvents) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopped {
+		return
+	}
+
+	queue := func(event Event, files []string) {
+		for _, filename := range files {
+			prev, exists := d.pending[filename]
+			if !exists {
+				d.pending[filename] = event
+				d.order = append(d.order, filename)
+				continue
+			}
+
+			merged, ok := mergeEvents(prev, event)
+			_, known := d.hashes[filename]
+			switch {
+			case ok:
+				d.pending[filename] = merged
+
+			case known:
+				// relisted by a restart and gone already, callbacks knew
+				// of it so the delete stands, flush drops its hash
+				d.pending[filename] = DeleteEvent
+
+			default:
+				delete(d.pending, filename)
+			}
+		}
+	}
+	queue(CreateEvent, events.created)
+	queue(UpdateEvent, events.updated)
+	queue(DeleteEvent, events.deleted)
+
+	if len(d.order) == 0 {
+		return
+	}
+
+	if d.timer == nil {
+		d.timer = time.AfterFunc(d.window, d.flush)
+	} else {
+		d.timer.Reset(d.window)
+	}
+}
+
+// sync takes the complete list of files of a backend that just started,
+// files reported before and missing from it are deleted.
+func (d *dispatcher) sync(files []string) {
+	d.mu.Lock()
+	listed := map[string]struct{}{}
+	for _, filename := range files {
+		listed[filename] = struct{}{}
+	}
+	deleted := []string{}
+	for filename := range d.hashes {
+		if _, exists := listed[filename]; !exists {
+			deleted = append(deleted, filename)
+		}
+	}
+	d.mu.Unlock()
+
+	d.add(&fileEvents{created: files, deleted: deleted})
+}
+
+// stop drops pending events and queued callbacks, a flush under way
+// dispatches nothing more.
+func (d *dispatcher) stop() {
+	d.mu.Lock()
+	d.stopped = true
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.mu.Unlock()
+
+	for _, q := range d.queues {
+		q.stop()
+	}
+}
+
+// changed hashes filename and reports whether its contents differ from the
+// last time it was reported.
+func (d *dispatcher) changed(filename string) bool {
+	contents, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		// removed meanwhile, its delete is on the way
+		return false
+	}
+	if err != nil {
+		return true
+	}
+
+	sum := sha256.Sum256(contents)
+	prev, exists := d.hashes[filename]
+	d.hashes[filename] = sum
+	return !exists || prev != sum
+}
+
+func (d *dispatcher) flush() {
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return
+	}
+	files := map[Event][]string{}
+	for _, filename := range d.order {
+		event, exists := d.pending[filename]
+		if !exists {
+			continue
+		}
+		// order may list a file twice if its events cancelled out before
+		delete(d.pending, filename)
+
+		switch event {
+		case CreateEvent:
+			// listed again after a restart, only news if it changed meanwhile
+			_, known := d.hashes[filename]
+			if !d.changed(filename) {
+				continue
+			}
+			if known {
+				event = UpdateEvent
+			}
+
+		case UpdateEvent:
+			if !d.changed(filename) {
+				continue
+			}
+
+		case DeleteEvent:
+			delete(d.hashes, filename)
+		}
+		files[event] = append(files[event], filename)
+	}
+	d.order = nil
+	d.mu.Unlock()
+
+	for _, event := range []Event{CreateEvent, UpdateEvent, DeleteEvent} {
+		d.mu.Lock()
+		stopped := d.stopped
+		d.mu.Unlock()
+		if stopped {
+			return
+		}
+
+		if len(files[event]) > 0 {
+			d.dispatch(event, files[event])
+		}
+	}
+}
+
+func (d *dispatcher) dispatch(event Event, files []string) {
+	for matcher, cb := range d.callbacks {
 		target := make([]string, 0)
 		patterns := strings.Split(matcher, ",")
-		for _, pattern := range patterns {
-			for _, filename := range files {
-				name := filepath.Base(filename)
-				if must.Must(filepath.Match(pattern, name)) {
-					target = append(target, filename)
-				}
+		for _, filename := range files {
+			if matchAny(patterns, filename) {
+				target = append(target, filename)
 			}
 		}
 		if len(target) > 0 {
-			go func() {
+			d.queues[matcher].push(func() {
 				err := cb(event, target)
 				if err != nil {
 					logger.Errorf("%+v", err)
 				}
-			}()
+			})
 		}
 	}
 }
-
-func dispatch(files *fileEvents, callbacks CallbackMap) {
-	dispatchEvent(callbacks, CreateEvent, files.created)
-	dispatchEvent(callbacks, UpdateEvent, files.updated)
-	dispatchEvent(callbacks, DeleteEvent, files.deleted)
-}
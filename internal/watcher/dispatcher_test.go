@@ -0,0 +1,236 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMergeEvents(t *testing.T) {
+	tests := []struct {
+		prev, next Event
+		merged     Event
+		ok         bool
+	}{
+		{CreateEvent, CreateEvent, CreateEvent, true},
+		{CreateEvent, UpdateEvent, CreateEvent, true},
+		{CreateEvent, DeleteEvent, 0, false},
+		{UpdateEvent, CreateEvent, CreateEvent, true},
+		{UpdateEvent, UpdateEvent, UpdateEvent, true},
+		{UpdateEvent, DeleteEvent, DeleteEvent, true},
+		{DeleteEvent, CreateEvent, UpdateEvent, true},
+		{DeleteEvent, UpdateEvent, UpdateEvent, true},
+		{DeleteEvent, DeleteEvent, DeleteEvent, true},
+	}
+
+	for _, tt := range tests {
+		merged, ok := mergeEvents(tt.prev, tt.next)
+		if ok != tt.ok || (ok && merged != tt.merged) {
+			t.Errorf("mergeEvents(%s, %s) = %s, %v, want %s, %v", tt.prev, tt.next, merged, ok, tt.merged, tt.ok)
+		}
+	}
+}
+
+// recorder collects what a dispatcher calls back with.
+type recorder struct {
+	mu  sync.Mutex
+	got map[Event][]string
+}
+
+func (rec *recorder) callback(event Event, files []string) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.got[event] = append(rec.got[event], files...)
+	return nil
+}
+
+// take returns what was dispatched since the last call, once the queued
+// callbacks ran.
+func (rec *recorder) take(t *testing.T, d *dispatcher) map[Event][]string {
+	t.Helper()
+
+	q := d.queues["*"]
+	q.mu.Lock()
+	stopped := q.stopped
+	q.mu.Unlock()
+
+	if !stopped {
+		done := make(chan struct{})
+		q.push(func() { close(done) })
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("callbacks did not run")
+		}
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	got := rec.got
+	rec.got = map[Event][]string{}
+	for _, files := range got {
+		slices.Sort(files)
+	}
+	return got
+}
+
+func TestDispatcherFlush(t *testing.T) {
+	write := func(t *testing.T, filename, contents string) {
+		t.Helper()
+		if err := os.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remove := func(t *testing.T, filename string) {
+		t.Helper()
+		if err := os.Remove(filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		known []string // files reported before
+		run   func(t *testing.T, d *dispatcher, file func(string) string)
+		want  map[Event][]string
+	}{
+		{
+			name: "create",
+			run: func(t *testing.T, d *dispatcher, file func(string) string) {
+				write(t, file("a"), "a")
+				d.add(&fileEvents{created: []string{file("a")}})
+			},
+			want: map[Event][]string{CreateEvent: {"a"}},
+		},
+		{
+			name:  "update",
+			known: []string{"a", "b"},
+			run: func(t *testing.T, d *dispatcher, file func(string) string) {
+				write(t, file("a"), "changed")
+				d.add(&fileEvents{updated: []string{file("a"), file("b")}})
+			},
+			want: map[Event][]string{UpdateEvent: {"a"}},
+		},
+		{
+			name:  "delete",
+			known: []string{"a"},
+			run: func(t *testing.T, d *dispatcher, file func(string) string) {
+				remove(t, file("a"))
+				d.add(&fileEvents{deleted: []string{file("a")}})
+			},
+			want: map[Event][]string{DeleteEvent: {"a"}},
+		},
+		{
+			name: "create then delete cancels out",
+			run: func(t *testing.T, d *dispatcher, file func(string) string) {
+				d.add(&fileEvents{created: []string{file("a")}})
+				d.add(&fileEvents{deleted: []string{file("a")}})
+			},
+			want: map[Event][]string{},
+		},
+		{
+			name:  "atomic save",
+			known: []string{"a"},
+			run: func(t *testing.T, d *dispatcher, file func(string) string) {
+				d.add(&fileEvents{deleted: []string{file("a")}})
+				write(t, file("a"), "changed")
+				d.add(&fileEvents{created: []string{file("a")}})
+			},
+			want: map[Event][]string{UpdateEvent: {"a"}},
+		},
+		{
+			name:  "bursts become the net change",
+			known: []string{"a"},
+			run: func(t *testing.T, d *dispatcher, file func(string) string) {
+				write(t, file("b"), "b")
+				d.add(&fileEvents{created: []string{file("b")}, updated: []string{file("a")}})
+				d.add(&fileEvents{updated: []string{file("b")}})
+				remove(t, file("a"))
+				d.add(&fileEvents{deleted: []string{file("a")}})
+			},
+			want: map[Event][]string{CreateEvent: {"b"}, DeleteEvent: {"a"}},
+		},
+		{
+			name:  "relisted unchanged",
+			known: []string{"a", "b"},
+			run: func(t *testing.T, d *dispatcher, file func(string) string) {
+				d.sync([]string{file("a"), file("b")})
+			},
+			want: map[Event][]string{},
+		},
+		{
+			name:  "relisted after changes",
+			known: []string{"a", "b"},
+			run: func(t *testing.T, d *dispatcher, file func(string) string) {
+				write(t, file("a"), "changed")
+				remove(t, file("b"))
+				write(t, file("c"), "c")
+				d.sync([]string{file("a"), file("c")})
+			},
+			want: map[Event][]string{CreateEvent: {"c"}, UpdateEvent: {"a"}, DeleteEvent: {"b"}},
+		},
+		{
+			name:  "relisted then deleted",
+			known: []string{"a"},
+			run: func(t *testing.T, d *dispatcher, file func(string) string) {
+				d.sync([]string{file("a")})
+				remove(t, file("a"))
+				d.add(&fileEvents{deleted: []string{file("a")}})
+			},
+			want: map[Event][]string{DeleteEvent: {"a"}},
+		},
+		{
+			name: "stopped",
+			run: func(t *testing.T, d *dispatcher, file func(string) string) {
+				write(t, file("a"), "a")
+				d.add(&fileEvents{created: []string{file("a")}})
+				d.stop()
+			},
+			want: map[Event][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := func(name string) string { return filepath.Join(dir, name) }
+
+			rec := &recorder{got: map[Event][]string{}}
+			// flushed by hand, the timer never fires
+			d := newDispatcher(CallbackMap{"*": rec.callback}, int(time.Hour/time.Millisecond))
+			defer d.stop()
+
+			if len(tt.known) > 0 {
+				known := []string{}
+				for _, name := range tt.known {
+					write(t, file(name), name)
+					known = append(known, file(name))
+				}
+				d.add(&fileEvents{created: known})
+				d.flush()
+				rec.take(t, d)
+			}
+
+			tt.run(t, d, file)
+			d.flush()
+
+			got := map[Event][]string{}
+			for event, files := range rec.take(t, d) {
+				for _, filename := range files {
+					got[event] = append(got[event], filepath.Base(filename))
+				}
+			}
+
+			for _, event := range []Event{CreateEvent, UpdateEvent, DeleteEvent} {
+				if !slices.Equal(got[event], tt.want[event]) {
+					t.Errorf("%s = %q, want %q", event, got[event], tt.want[event])
+				}
+			}
+		})
+	}
+}
@@ -1,39 +1,61 @@
 package watcher
 
 import (
+	"errors"
 	"io/fs"
-	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/sgq995/nova/internal/module"
 	"github.com/sgq995/nova/internal/must"
 )
 
-func scanFiles(root string, patterns []string) (map[string]time.Time, error) {
+// matchPattern matches a CallbackMap pattern, those with a slash are matched
+// on the module-relative path and may use "**", the others on the base name.
+func matchPattern(pattern, filename string) bool {
+	if strings.Contains(pattern, "/") {
+		rel := filepath.ToSlash(module.Rel(filename))
+		return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+	}
+	return must.Must(filepath.Match(pattern, filepath.Base(filename)))
+}
+
+func matchAny(patterns []string, filename string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, filename) {
+			return true
+		}
+	}
+	return false
+}
+
+func scanFiles(root string, f *filter) (map[string]time.Time, error) {
 	files := map[string]time.Time{}
 	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			// removed while walking, the next event reports it
+			return nil
+		}
 		if err != nil {
 			return err
 		}
 
-		matched := false
-		for _, pattern := range patterns {
-			name := filepath.Base(path)
-			if must.Must(filepath.Match(pattern, name)) {
-				matched = true
-				break
+		if d.IsDir() {
+			if f.skipDir(path) {
+				return filepath.SkipDir
 			}
-		}
-
-		if !matched {
 			return nil
 		}
 
-		if d.IsDir() {
+		if !f.match(path) {
 			return nil
 		}
 
 		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
 		if err != nil {
 			return err
 		}
@@ -48,20 +70,6 @@ func scanFiles(root string, patterns []string) (map[string]time.Time, error) {
 	return files, nil
 }
 
-func lookupFiles(paths []string) (map[string]time.Time, error) {
-	files := map[string]time.Time{}
-
-	for _, filename := range paths {
-		info, err := os.Stat(filename)
-		if err != nil {
-			return nil, err
-		}
-		files[filename] = info.ModTime()
-	}
-
-	return files, nil
-}
-
 type fileEvents struct {
 	created []string
 	updated []string
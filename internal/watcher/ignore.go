@@ -0,0 +1,215 @@
+package watcher
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sgq995/nova/internal/config"
+	"github.com/sgq995/nova/internal/logger"
+	"github.com/sgq995/nova/internal/module"
+)
+
+// ignoreFiles are read from the module root, in order, rules of later files
+// take precedence. Below the root only .gitignore files are read.
+var ignoreFiles = []string{".gitignore", ".novaignore"}
+
+// ignoreRule is a line of a .gitignore file.
+type ignoreRule struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// a slash other than the trailing one anchors the pattern to the root,
+	// otherwise it matches at any depth
+	if !strings.Contains(line, "/") {
+		line = "**/" + line
+	}
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	rule.segments = strings.Split(line, "/")
+	return rule, true
+}
+
+// ignoreList decides which module-relative paths are left out, the last
+// matching rule wins like in .gitignore. The rules of a nested .gitignore
+// apply below its directory only, over the ones of the directories above.
+type ignoreList struct {
+	rules []ignoreRule
+	// overrides are applied last, after the nested files
+	overrides []ignoreRule
+
+	// nested holds the rules of the .gitignore of each directory below the
+	// root, read the first time a path in it is matched. A nil map reads
+	// none.
+	mu     sync.Mutex
+	nested map[string][]ignoreRule
+}
+
+func parseIgnoreRules(lines ...string) []ignoreRule {
+	rules := []ignoreRule{}
+	for _, line := range lines {
+		if rule, ok := parseIgnoreRule(line); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (l *ignoreList) add(lines ...string) {
+	l.rules = append(l.rules, parseIgnoreRules(lines...)...)
+}
+
+func (l *ignoreList) ignored(rel string, isDir bool) bool {
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+
+	ignored := matchRules(l.rules, segments, isDir, false)
+	if l.nested != nil {
+		for i := 1; i < len(segments); i++ {
+			dir := strings.Join(segments[:i], "/")
+			ignored = matchRules(l.nestedRules(dir), segments[i:], isDir, ignored)
+		}
+	}
+	return matchRules(l.overrides, segments, isDir, ignored)
+}
+
+// matchRules applies rules to a path split in segments, ignored is the
+// outcome of the rules before them.
+func matchRules(rules []ignoreRule, segments []string, isDir bool, ignored bool) bool {
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if matchSegments(rule.segments, segments) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// nestedRules returns the rules of the .gitignore of dir, a module-relative
+// slash separated path. The file is read once, later edits need a restart
+// like the ones of the root files.
+func (l *ignoreList) nestedRules(dir string) []ignoreRule {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if rules, loaded := l.nested[dir]; loaded {
+		return rules
+	}
+
+	lines, err := readLines(module.Join(filepath.FromSlash(dir), ".gitignore"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logger.Warnf("[watcher] %s/.gitignore: %+v", dir, err)
+	}
+	rules := parseIgnoreRules(lines...)
+	l.nested[dir] = rules
+	return rules
+}
+
+func readLines(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := []string{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	return lines, s.Err()
+}
+
+// loadIgnore builds the rules of the project: the version control and output
+// directories, the ignore files of the root, the .gitignore files below it
+// and then watcher.ignore.
+func loadIgnore(c *config.Config) (*ignoreList, error) {
+	l := &ignoreList{nested: map[string][]ignoreRule{}}
+	l.add(".git/", "node_modules/", "/"+filepath.ToSlash(c.Codegen.OutDir)+"/")
+
+	for _, name := range ignoreFiles {
+		lines, err := readLines(module.Join(name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		l.add(lines...)
+	}
+
+	l.overrides = parseIgnoreRules(c.Watcher.Ignore...)
+
+	return l, nil
+}
+
+// matchSegments matches a slash separated path against a glob, where a "**"
+// segment matches any number of directories.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// filter selects the paths a watcher reports.
+type filter struct {
+	patterns []string
+	ignore   *ignoreList
+}
+
+// skipDir reports whether dir must not be walked nor watched.
+func (f *filter) skipDir(dir string) bool {
+	rel := module.Rel(dir)
+	return rel != "." && f.ignore.ignored(rel, true)
+}
+
+// match reports whether the file filename is watched.
+func (f *filter) match(filename string) bool {
+	return matchAny(f.patterns, filename) && !f.ignore.ignored(module.Rel(filename), false)
+}
@@ -0,0 +1,123 @@
+package watcher
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIgnoreList(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []string
+		rel     string
+		isDir   bool
+		ignored bool
+	}{
+		{name: "glob", rules: []string{"*.log"}, rel: "debug.log", ignored: true},
+		{name: "glob at any depth", rules: []string{"*.log"}, rel: "logs/debug.log", ignored: true},
+		{name: "glob mismatch", rules: []string{"*.log"}, rel: "debug.go"},
+		{name: "single character", rules: []string{"?.go"}, rel: "a.go", ignored: true},
+		{name: "single character mismatch", rules: []string{"?.go"}, rel: "ab.go"},
+		{name: "character class", rules: []string{"file[0-9].txt"}, rel: "file3.txt", ignored: true},
+		{name: "character class mismatch", rules: []string{"file[0-9].txt"}, rel: "filex.txt"},
+
+		{name: "anchored", rules: []string{"/build"}, rel: "build", isDir: true, ignored: true},
+		{name: "anchored below the root", rules: []string{"/build"}, rel: "src/build", isDir: true},
+		{name: "inner slash anchors", rules: []string{"docs/*.md"}, rel: "docs/a.md", ignored: true},
+		{name: "inner slash below the root", rules: []string{"docs/*.md"}, rel: "site/docs/a.md"},
+		{name: "star stays in its segment", rules: []string{"docs/*.md"}, rel: "docs/sub/a.md"},
+		{name: "double star", rules: []string{"docs/**/*.md"}, rel: "docs/a/b/c.md", ignored: true},
+		{name: "double star matches no directory", rules: []string{"docs/**/*.md"}, rel: "docs/c.md", ignored: true},
+		{name: "leading double star", rules: []string{"**/temp"}, rel: "a/b/temp", ignored: true},
+
+		{name: "directory rule on a directory", rules: []string{"build/"}, rel: "build", isDir: true, ignored: true},
+		{name: "directory rule at any depth", rules: []string{"build/"}, rel: "src/build", isDir: true, ignored: true},
+		{name: "directory rule on a file", rules: []string{"build/"}, rel: "build"},
+
+		{name: "negated", rules: []string{"*.log", "!keep.log"}, rel: "keep.log"},
+		{name: "negation leaves others", rules: []string{"*.log", "!keep.log"}, rel: "debug.log", ignored: true},
+		{name: "last rule wins", rules: []string{"!keep.log", "*.log"}, rel: "keep.log", ignored: true},
+		{name: "escaped bang", rules: []string{`\!important`}, rel: "!important", ignored: true},
+		{name: "comment", rules: []string{"#notes"}, rel: "#notes"},
+		{name: "escaped hash", rules: []string{`\#notes`}, rel: "#notes", ignored: true},
+		{name: "trailing spaces", rules: []string{"*.tmp  \t"}, rel: "a.tmp", ignored: true},
+		{name: "blank lines", rules: []string{"", "   "}, rel: "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &ignoreList{}
+			l.add(tt.rules...)
+
+			if got := l.ignored(tt.rel, tt.isDir); got != tt.ignored {
+				t.Errorf("ignored(%q, %v) with %q = %v, want %v", tt.rel, tt.isDir, tt.rules, got, tt.ignored)
+			}
+		})
+	}
+}
+
+func TestIgnoreListNested(t *testing.T) {
+	tests := []struct {
+		name    string
+		nested  map[string][]string // directory to the rules of its .gitignore
+		rel     string
+		isDir   bool
+		ignored bool
+	}{
+		{name: "below its directory", nested: map[string][]string{"web": {"*.gen.js"}}, rel: "web/a.gen.js", ignored: true},
+		{name: "deeper below its directory", nested: map[string][]string{"web": {"*.gen.js"}}, rel: "web/lib/a.gen.js", ignored: true},
+		{name: "outside its directory", nested: map[string][]string{"web": {"*.gen.js"}}, rel: "api/a.gen.js"},
+		{name: "anchored to its directory", nested: map[string][]string{"web": {"/dist"}}, rel: "web/dist", isDir: true, ignored: true},
+		{name: "anchored below its directory", nested: map[string][]string{"web": {"/dist"}}, rel: "web/lib/dist", isDir: true},
+		{name: "over the root", nested: map[string][]string{"web": {"!keep.log"}}, rel: "web/keep.log"},
+		{name: "deeper over shallower", nested: map[string][]string{"web": {"*.tmp"}, "web/lib": {"!a.tmp"}}, rel: "web/lib/a.tmp"},
+		{name: "overridden by watcher.ignore", nested: map[string][]string{"web": {"!debug.log"}}, rel: "web/debug.log", ignored: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &ignoreList{nested: map[string][]ignoreRule{}}
+			l.add("*.log")
+			l.overrides = parseIgnoreRules("debug.log")
+
+			// every directory is loaded so nothing is read from disk
+			segments := strings.Split(tt.rel, "/")
+			for i := 1; i < len(segments); i++ {
+				dir := strings.Join(segments[:i], "/")
+				l.nested[dir] = parseIgnoreRules(tt.nested[dir]...)
+			}
+
+			if got := l.ignored(tt.rel, tt.isDir); got != tt.ignored {
+				t.Errorf("ignored(%q, %v) with %q = %v, want %v", tt.rel, tt.isDir, tt.nested, got, tt.ignored)
+			}
+		})
+	}
+}
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/b/c", false},
+		{"a/*", "a/b", true},
+		{"a/*", "a", false},
+		{"**", "a/b/c", true},
+		{"**/c", "c", true},
+		{"**/c", "a/b/c", true},
+		{"a/**", "a/b/c", true},
+		{"a/**/d", "a/d", true},
+		{"a/**/d", "a/b/c/d", true},
+		{"a/**/d", "a/b/c/e", false},
+		{"**/b/**", "a/b/c", true},
+	}
+
+	for _, tt := range tests {
+		got := matchSegments(strings.Split(tt.pattern, "/"), strings.Split(tt.name, "/"))
+		if got != tt.match {
+			t.Errorf("matchSegments(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.match)
+		}
+	}
+}
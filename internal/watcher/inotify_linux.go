@@ -0,0 +1,313 @@
+//go:build linux
+
+package watcher
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/sgq995/nova/internal/config"
+	"golang.org/x/sys/unix"
+)
+
+// errRootGone fails the watcher once its root is deleted or moved, nothing
+// below it can be watched anymore.
+var errRootGone = errors.New("watched directory was removed or moved")
+
+const inotifyMask = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_DELETE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF |
+	unix.IN_ONLYDIR
+
+// inotify watches every directory below root. Events only say which paths
+// to look at again, the files are stat'ed once a batch is read so a file
+// created and removed within the batch reports nothing.
+type inotify struct {
+	root   string
+	filter *filter
+
+	fd   int
+	file *os.File
+
+	watches map[int]string // watch descriptor to directory
+	dirs    map[string]int
+	files   map[string]time.Time
+}
+
+func newInotify(root string, f *filter) (backend, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	w := &inotify{
+		root:   root,
+		filter: f,
+		fd:     fd,
+		// non-blocking, so reads go through the runtime poller and Close
+		// interrupts them
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: map[int]string{},
+		dirs:    map[string]int{},
+		files:   map[string]time.Time{},
+	}
+
+	if err := w.addTree(root, nil); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *inotify) addWatch(dir string) error {
+	wd, err := unix.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.ENOTDIR) {
+		// removed before it could be watched, its parent reports it
+		return nil
+	}
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	w.watches[wd] = dir
+	w.dirs[dir] = wd
+	return nil
+}
+
+// addTree watches dir and every directory below it, the files found are
+// marked in touched since events before the watch was added are lost.
+func (w *inotify) addTree(dir string, touched map[string]bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if w.filter.skipDir(path) {
+				return filepath.SkipDir
+			}
+			return w.addWatch(path)
+		}
+
+		if touched != nil && w.filter.match(path) {
+			touched[path] = true
+		}
+		return nil
+	})
+}
+
+// removeTree forgets dir and everything below it. Watches of deleted
+// directories are already gone, those of directories moved out are removed.
+func (w *inotify) removeTree(dir string, touched map[string]bool) {
+	prefix := dir + string(filepath.Separator)
+
+	for path := range w.files {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			if _, exists := touched[path]; !exists {
+				touched[path] = false
+			}
+		}
+	}
+
+	for path, wd := range w.dirs {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, wd)
+			delete(w.dirs, path)
+		}
+	}
+}
+
+func (w *inotify) forget(wd int) {
+	dir, exists := w.watches[wd]
+	if !exists {
+		return
+	}
+	delete(w.watches, wd)
+	if w.dirs[dir] == wd {
+		delete(w.dirs, dir)
+	}
+}
+
+func (w *inotify) name() string {
+	return config.WatcherInotify
+}
+
+func (w *inotify) run(ctx context.Context, emit func(*fileEvents)) error {
+	defer w.file.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		w.file.Close()
+	})
+	defer stop()
+
+	files, err := scanFiles(w.root, w.filter)
+	if err != nil {
+		return err
+	}
+	emit(diff(w.files, files))
+	w.files = files
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		// the events before a failure are still news
+		events, err := w.handle(buf[:n])
+		if events != nil {
+			emit(events)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle reads a batch of inotify events, a renamed file is a delete of its
+// old name and a create of the new one, unless the new name existed already,
+// which is how editors save atomically. Losing the root fails with the
+// deletes of every file known.
+func (w *inotify) handle(buf []byte) (*fileEvents, error) {
+	touched := map[string]bool{} // path to whether its contents were written
+	overflow := false
+	rootGone := false
+
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buf); {
+		raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		start := offset + unix.SizeofInotifyEvent
+		offset = start + int(raw.Len)
+
+		name := strings.TrimRight(string(buf[start:offset]), "\x00")
+		mask := raw.Mask
+		wd := int(raw.Wd)
+
+		if mask&unix.IN_Q_OVERFLOW != 0 {
+			overflow = true
+			continue
+		}
+
+		dir, exists := w.watches[wd]
+		if !exists {
+			continue
+		}
+		path := dir
+		if name != "" {
+			path = filepath.Join(dir, name)
+		}
+
+		switch {
+		case mask&unix.IN_IGNORED != 0:
+			w.forget(wd)
+			rootGone = rootGone || dir == w.root
+
+		case mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0:
+			// subdirectories are reported by their parent too
+			if dir == w.root {
+				w.removeTree(dir, touched)
+				rootGone = true
+			}
+
+		case mask&unix.IN_ISDIR != 0:
+			if mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+				// addTree leaves ignored directories out
+				if err := w.addTree(path, touched); err != nil {
+					return nil, err
+				}
+			} else if mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0 {
+				w.removeTree(path, touched)
+			}
+
+		case w.filter.match(path):
+			written := mask&(unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO) != 0
+			touched[path] = touched[path] || written
+		}
+	}
+
+	if rootGone {
+		w.removeTree(w.root, touched)
+		return w.settle(touched), errRootGone
+	}
+	if overflow {
+		return w.rescan()
+	}
+	return w.settle(touched), nil
+}
+
+// rescan rebuilds the state after the kernel dropped events, directories
+// deleted or replaced meanwhile lose their watches.
+func (w *inotify) rescan() (*fileEvents, error) {
+	for dir, wd := range w.dirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, wd)
+			delete(w.dirs, dir)
+		}
+	}
+
+	if err := w.addTree(w.root, nil); err != nil {
+		return nil, err
+	}
+
+	// a directory replaced by another one got a new watch
+	for wd, dir := range w.watches {
+		if w.dirs[dir] != wd {
+			unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, wd)
+		}
+	}
+
+	files, err := scanFiles(w.root, w.filter)
+	if err != nil {
+		return nil, err
+	}
+
+	events := diff(w.files, files)
+	w.files = files
+	return events, nil
+}
+
+func (w *inotify) settle(touched map[string]bool) *fileEvents {
+	events := &fileEvents{
+		created: []string{},
+		updated: []string{},
+		deleted: []string{},
+	}
+
+	for path, written := range touched {
+		_, known := w.files[path]
+
+		info, err := os.Stat(path)
+		exists := err == nil && info.Mode().IsRegular()
+
+		switch {
+		case exists && !known:
+			events.created = append(events.created, path)
+			w.files[path] = info.ModTime()
+
+		case !exists && known:
+			events.deleted = append(events.deleted, path)
+			delete(w.files, path)
+
+		case exists && written:
+			events.updated = append(events.updated, path)
+			w.files[path] = info.ModTime()
+		}
+	}
+
+	return events
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package watcher
+
+import "errors"
+
+func newInotify(root string, f *filter) (backend, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,78 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sgq995/nova/internal/config"
+	"github.com/sgq995/nova/internal/logger"
+)
+
+const (
+	minBackoff = 250 * time.Millisecond
+	maxBackoff = 30 * time.Second
+
+	// a watcher running for this long is healthy again, its next failure
+	// starts over from minBackoff
+	stableAfter = time.Minute
+)
+
+var errStopped = errors.New("watcher stopped")
+
+// Status describes a supervised watcher.
+type Status struct {
+	Dir      string    `json:"dir"`
+	Backend  string    `json:"backend"`
+	Running  bool      `json:"running"`
+	Failures int       `json:"failures"`
+	Error    string    `json:"error,omitempty"`
+	Since    time.Time `json:"since"`
+}
+
+// Supervise watches dir like WatchDir until ctx is done, restarting the
+// watcher with an exponential backoff whenever it fails. onStatus is called
+// on every change of its Status.
+func Supervise(ctx context.Context, c *config.Config, dir string, callbacks CallbackMap, onStatus func(Status)) {
+	d := newDispatcher(callbacks, c.Watcher.Sync)
+	defer d.stop()
+
+	status := Status{Dir: dir}
+	backoff := minBackoff
+
+	for {
+		start := time.Now()
+		err := watchDir(ctx, c, dir, d, func(backend string) {
+			status.Backend = backend
+			status.Running = true
+			status.Error = ""
+			status.Since = time.Now()
+			onStatus(status)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errStopped
+		}
+
+		if time.Since(start) > stableAfter {
+			backoff = minBackoff
+		}
+
+		logger.Errorf("[watcher] %s: %+v, restarting in %s", dir, err, backoff)
+		status.Running = false
+		status.Failures++
+		status.Error = err.Error()
+		status.Since = time.Now()
+		onStatus(status)
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
@@ -2,52 +2,127 @@ package watcher
 
 import (
 	"context"
-	"maps"
+	"fmt"
 	"slices"
 	"strings"
 	"time"
 
+	"github.com/sgq995/nova/internal/config"
+	"github.com/sgq995/nova/internal/logger"
 	"github.com/sgq995/nova/internal/module"
 )
 
-func WatchDir(ctx context.Context, dir string, callbacks CallbackMap) error {
+// backend reports the changes of the files below a root, the first batch
+// creates every file that already exists.
+type backend interface {
+	name() string
+	run(ctx context.Context, emit func(*fileEvents)) error
+}
+
+func newBackend(c *config.WatcherConfig, root string, f *filter) (backend, error) {
+	switch c.Backend {
+	case config.WatcherPoll:
+		return newPoller(root, f, c.Discovery), nil
+
+	case config.WatcherInotify:
+		return newInotify(root, f)
+
+	case config.WatcherAuto, "":
+		b, err := newInotify(root, f)
+		if err != nil {
+			logger.Debugf("[watcher] inotify: %+v, polling %s instead", err, module.Rel(root))
+			return newPoller(root, f, c.Discovery), nil
+		}
+		return b, nil
+
+	default:
+		return nil, fmt.Errorf("nova: unknown watcher backend %q", c.Backend)
+	}
+}
+
+// WatchDir reports the changes below dir to callbacks until ctx is done,
+// leaving out the paths ignored by the project.
+func WatchDir(ctx context.Context, c *config.Config, dir string, callbacks CallbackMap) error {
+	d := newDispatcher(callbacks, c.Watcher.Sync)
+	defer d.stop()
+
+	return watchDir(ctx, c, dir, d, func(string) {})
+}
+
+// watchDir runs a backend for dir, started is called with its name once it
+// is watching.
+func watchDir(ctx context.Context, c *config.Config, dir string, d *dispatcher, started func(string)) error {
 	root := module.Abs(dir)
-	files := map[string]time.Time{}
 
 	patterns := []string{}
-	for matcher := range callbacks {
+	for matcher := range d.callbacks {
 		patterns = slices.Concat(patterns, strings.Split(matcher, ","))
 	}
 
-	// TODO: config scan time
-	filesTicker := time.NewTicker(250 * time.Millisecond)
-	defer filesTicker.Stop()
+	ignore, err := loadIgnore(c)
+	if err != nil {
+		return err
+	}
+	f := &filter{patterns: patterns, ignore: ignore}
+
+	b, err := newBackend(&c.Watcher, root, f)
+	if err != nil {
+		return err
+	}
+	started(b.name())
+
+	// the first batch lists every file, which tells the dispatcher what was
+	// removed while no backend was running
+	synced := false
+	return b.run(ctx, func(events *fileEvents) {
+		if !synced {
+			synced = true
+			d.sync(events.created)
+			return
+		}
+		d.add(events)
+	})
+}
+
+// poller finds changes by walking the whole tree, it works everywhere but
+// costs a walk per interval.
+type poller struct {
+	root     string
+	filter   *filter
+	interval time.Duration
+}
+
+func newPoller(root string, f *filter, interval int) *poller {
+	return &poller{
+		root:     root,
+		filter:   f,
+		interval: time.Duration(interval) * time.Millisecond,
+	}
+}
+
+func (p *poller) name() string {
+	return config.WatcherPoll
+}
+
+func (p *poller) run(ctx context.Context, emit func(*fileEvents)) error {
+	files := map[string]time.Time{}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
 
 	for {
+		newFiles, err := scanFiles(p.root, p.filter)
+		if err != nil {
+			return err
+		}
+		emit(diff(files, newFiles))
+		files = newFiles
+
 		select {
 		case <-ctx.Done():
 			return nil
 
-		case <-filesTicker.C:
-			newFiles, err := scanFiles(root, patterns)
-			if err != nil {
-				return err
-			}
-			events := diff(files, newFiles)
-			files = newFiles
-			dispatch(events, callbacks)
-
-		default:
-			paths := slices.Collect(maps.Keys(files))
-			newFiles, err := lookupFiles(paths)
-			if err != nil {
-				return err
-			}
-			events := diff(files, newFiles)
-			files = newFiles
-			dispatch(events, callbacks)
-			// TODO: config
-			time.Sleep(250 * time.Millisecond)
+		case <-ticker.C:
 		}
 	}
 }
@@ -0,0 +1,122 @@
+// Package plugin extends nova builds and the dev server without forking it.
+//
+// Plugins are registered from nova.plugins.go, a file at the root of the
+// project that nova compiles into a CLI of its own:
+//
+//	//go:build nova
+//
+//	package main
+//
+//	import (
+//		"github.com/sgq995/nova/plugin"
+//		"example.com/nova-markdown"
+//	)
+//
+//	func init() {
+//		plugin.Register(markdown.New())
+//	}
+//
+// The build constraint keeps the file out of the project packages. A plugin
+// implements Plugin plus the hooks it needs: Scanner, ESBuild, Router,
+// Codegen and Middleware.
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// Plugin is the base of every plugin, the name shows up in nova logs.
+type Plugin interface {
+	Name() string
+}
+
+// Scanner claims file extensions like ".svelte" or ".md", files with them
+// are bundled as scripts when pages and templates reference them. esbuild
+// knows nothing about them, the plugin loads them from its ESBuild hook.
+type Scanner interface {
+	Plugin
+	Extensions() []string
+}
+
+// ESBuild adds esbuild plugins to the dev and production builds, they run
+// before the ones of nova.
+type ESBuild interface {
+	Plugin
+	ESBuildPlugins(dev bool) []api.Plugin
+}
+
+// Route is served by the dev server in its own process.
+type Route struct {
+	Pattern string
+	Handler http.Handler
+}
+
+// Router contributes routes to the dev server. They are served by nova
+// itself, use Codegen to add handlers to the generated servers.
+type Router interface {
+	Plugin
+	Routes() []Route
+}
+
+// Snippet is Go code added to the main function of a generated server, once
+// nova registered its routes on mux, an *http.ServeMux.
+type Snippet struct {
+	Imports map[string]string // package paths by alias
+	Setup   string
+}
+
+// Codegen adds code to the production server, and to the dev application
+// when dev is true. The dev server only forwards the routes it knows of to
+// the application, Router serves the rest in dev.
+type Codegen interface {
+	Plugin
+	Codegen(dev bool) Snippet
+}
+
+// Middleware wraps every request of the dev server, nova endpoints included.
+// The first registered plugin is the outermost middleware.
+type Middleware interface {
+	Plugin
+	Middleware(next http.Handler) http.Handler
+}
+
+var (
+	mu      sync.Mutex
+	plugins []Plugin
+)
+
+// Register adds p to nova, it is meant to be called from init functions. It
+// panics if a plugin with the same name is registered already.
+func Register(p Plugin) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if slices.ContainsFunc(plugins, func(other Plugin) bool { return other.Name() == p.Name() }) {
+		panic(fmt.Sprintf("nova: plugin %q registered twice", p.Name()))
+	}
+	plugins = append(plugins, p)
+}
+
+// Plugins returns the registered plugins in registration order.
+func Plugins() []Plugin {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return slices.Clone(plugins)
+}
+
+// Hooks returns the registered plugins implementing the hook T.
+func Hooks[T Plugin]() []T {
+	hooks := []T{}
+	for _, p := range Plugins() {
+		if hook, ok := p.(T); ok {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}